@@ -17,8 +17,14 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/golang/glog"
 	"k8s.io/kubernetes/pkg/api"
@@ -27,54 +33,118 @@ import (
 	"k8s.io/kubernetes/pkg/labels"
 )
 
+const (
+	// the annotation recording the machine sources which reported the node
+	annotationSources = "node-register/sources"
+	// the annotation recording the id of the machine the node was registered from
+	annotationMachineID = "node-register/machine-id"
+	// the annotation recording the version of fleet on the machine
+	annotationVersion = "node-register/fleet-version"
+)
+
 // NewKubernetesInterface creates a new client to speak to the kubernetes api service
-func NewKubernetesInterface() (*KubernetesInterface, error) {
-	glog.Infof("Creating a kubernetes api client, endpoint: %s", config.kubeAPI)
+func NewKubernetesInterface(cluster *ClusterTarget) (*KubernetesInterface, error) {
+	glog.Infof("Creating a kubernetes api client, cluster: %s, endpoint: %s", cluster.Name, cluster.API)
 	// step: create a configuration for kubernetes api
 	kubecfg := client.Config{
-		Host:     config.kubeAPI,
-		Insecure: config.kubeInsecure,
-		Version:  config.kubeVersion,
+		Host:     cluster.API,
+		Insecure: cluster.Insecure,
+		Version:  cluster.APIVersion,
 	}
 
 	// step: read in the token file is there is one
-	if config.kubeTokenFile != "" {
-		glog.V(4).Infof("Reading in the contents of the token file: %s", config.kubeTokenFile)
-		content, err := ioutil.ReadFile(config.kubeTokenFile)
+	token := cluster.Token
+	if cluster.TokenFile != "" {
+		glog.V(4).Infof("Reading in the contents of the token file: %s", cluster.TokenFile)
+		content, err := ioutil.ReadFile(cluster.TokenFile)
 		if err != nil {
 			return nil, fmt.Errorf("unable to read the token file: %s, error: %s",
-				config.kubeTokenFile, err)
+				cluster.TokenFile, err)
 		}
-		glog.V(5).Infof("Using the kubernetes token from file: %s", config.kubeTokenFile)
-		config.kubeToken = string(content)
+		glog.V(5).Infof("Using the kubernetes token from file: %s", cluster.TokenFile)
+		token = string(content)
 	}
 
 	// step: are we using a user token to authenticate?
-	if config.kubeToken != "" {
-		kubecfg.BearerToken = config.kubeToken
+	if token != "" {
+		kubecfg.BearerToken = token
 	}
 
 	// step: are we using a cert to authenticate
-	if config.kubeCert != "" {
+	if cluster.Cert != "" {
 		kubecfg.Insecure = false
 		kubecfg.TLSClientConfig = client.TLSClientConfig{
-			CAFile: config.kubeCert,
+			CAFile: cluster.Cert,
 		}
 	}
 
 	// step: create the kubernetes client
 	service := new(KubernetesInterface)
+	if _, err := client.New(&kubecfg); err != nil {
+		return nil, fmt.Errorf("unable to create a kubernetes api client, reason: %s", err)
+	}
+	service.kubecfg = kubecfg
+	service.version = cluster.APIVersion
+	service.dryRun = config.dryRun
+	service.cluster = cluster.Name
+	return service, nil
+}
+
+// kubeClient returns a client whose requests are cancelled when the context is done
+func (r KubernetesInterface) kubeClient(ctx context.Context) (*client.Client, error) {
+	kubecfg := r.kubecfg
+	kubecfg.WrapTransport = func(transport http.RoundTripper) http.RoundTripper {
+		return &contextTransport{ctx: ctx, next: transport}
+	}
+	// step: the tls transports are cached, so the client is cheap to create
 	kapi, err := client.New(&kubecfg)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create a kubernetes api client, reason: %s", err)
 	}
-	service.client = kapi
-	return service, nil
+
+	return kapi, nil
+}
+
+// nodes returns a client for the nodes, whose requests are cancelled when the context is done
+func (r KubernetesInterface) nodes(ctx context.Context) (client.NodeInterface, error) {
+	kapi, err := r.kubeClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return kapi.Nodes(), nil
+}
+
+// ServerVersion returns the major and minor version of the kubernetes api server
+func (r KubernetesInterface) ServerVersion(ctx context.Context) (int, int, error) {
+	kapi, err := r.kubeClient(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	info, err := kapi.ServerVersion()
+	if err != nil {
+		return 0, 0, err
+	}
+	// step: the minor version may carry a suffix, i.e. 5+
+	major, err := strconv.Atoi(strings.TrimRight(info.Major, "+"))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid major version: %s", info.Major)
+	}
+	minor, err := strconv.Atoi(strings.TrimRight(info.Minor, "+"))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid minor version: %s", info.Minor)
+	}
+
+	return major, minor, nil
 }
 
 // GetNodes get a list of registered kubernetes nodes
-func (r KubernetesInterface) GetNodes() ([]api.Node, error) {
-	nodes, err := r.client.Nodes().List(labels.Everything(), fields.Everything())
+func (r KubernetesInterface) GetNodes(ctx context.Context) ([]api.Node, error) {
+	kapi, err := r.nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := kapi.List(labels.Everything(), fields.Everything())
 	if err != nil {
 		return nil, err
 	}
@@ -82,11 +152,11 @@ func (r KubernetesInterface) GetNodes() ([]api.Node, error) {
 }
 
 // GetFailedNodes get a list of nodes in a failed state
-func (r KubernetesInterface) GetFailedNodes() ([]api.Node, error) {
+func (r KubernetesInterface) GetFailedNodes(ctx context.Context) ([]api.Node, error) {
 	// step: first get the list and then filter then
 	var filtered []api.Node
 
-	nodes, err := r.GetNodes()
+	nodes, err := r.GetNodes(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -107,10 +177,12 @@ func (r KubernetesInterface) GetFailedNodes() ([]api.Node, error) {
 }
 
 // IsRegistered checks to see if a node is registered with kubernetes
-func (r KubernetesInterface) IsRegistered(name string) (*api.Node, bool, error) {
+func (r KubernetesInterface) IsRegistered(ctx context.Context, name string) (*api.Node, bool, error) {
 	glog.V(5).Infof("Checking if node: %s is registered with kubernetes", name)
 	// step: get a list of nodes
-	nodes, err := r.GetNodes()
+	span := cycleTracer.Start("kubernetes.is_registered", "cluster", r.cluster, "node", name)
+	nodes, err := r.GetNodes(ctx)
+	span.End(err)
 	if err != nil {
 		return nil, false, err
 	}
@@ -123,35 +195,330 @@ func (r KubernetesInterface) IsRegistered(name string) (*api.Node, bool, error)
 }
 
 // DeleteNode delete the node from kubernetes
-func (r KubernetesInterface) DeleteNode(name string) error {
-	glog.V(3).Infof("Deleting the node: %s from kubernetes", name)
-	return r.client.Nodes().Delete(name)
+func (r KubernetesInterface) DeleteNode(ctx context.Context, name, reason string) error {
+	glog.V(3).Infof("Deleting the node: %s from kubernetes, reason: %s", name, reason)
+	if r.dryRun {
+		glog.Infof("[dry-run] skipping the deletion of the node: %s", name)
+		return nil
+	}
+	if err := r.checkAudit(auditDelete, name); err != nil {
+		return err
+	}
+	kapi, err := r.nodes(ctx)
+	if err != nil {
+		return err
+	}
+	before := r.auditState(ctx, name)
+	span := cycleTracer.Start("kubernetes.delete", "cluster", r.cluster, "node", name)
+	err = kapi.Delete(name)
+	span.End(err)
+	if err != nil {
+		return err
+	}
+	r.audit(auditDelete, name, before, nil, reason)
+
+	return nil
+}
+
+// UpdateNode patches the changed fields of the node in kubernetes. We never update the whole node, the vendored
+// api predates many of the node fields, i.e. the taints, which a full update would erase; it would also conflict
+// with the status updates made by the kubelet
+//
+// The taints are a list the merge patch replaces as a whole, so when they change the patch carries the resource version
+// the taints were read at, failing rather than overwriting any taints changed in the meantime
+func (r KubernetesInterface) UpdateNode(ctx context.Context, name string, changes map[string]*fieldChange, taints *nodeTaints, reason string) error {
+	glog.V(3).Infof("Updating the node: %s in kubernetes, reason: %s", name, reason)
+	if r.dryRun {
+		glog.Infof("[dry-run] skipping the update of the node: %s", name)
+		return nil
+	}
+	if err := r.checkAudit(auditUpdate, name); err != nil {
+		return err
+	}
+	resourceVersion := ""
+	if _, found := changes["spec/taints"]; found && taints != nil {
+		resourceVersion = taints.resourceVersion
+	}
+	patch, err := nodePatch(changes, resourceVersion)
+	if err != nil {
+		return err
+	}
+	kapi, err := r.kubeClient(ctx)
+	if err != nil {
+		return err
+	}
+	before := r.auditState(ctx, name)
+	span := cycleTracer.Start("kubernetes.update", "cluster", r.cluster, "node", name)
+	after := new(api.Node)
+	err = kapi.Patch(api.MergePatchType).Resource("nodes").Name(name).Body(patch).Do().Into(after)
+	span.End(err)
+	if err != nil {
+		return err
+	}
+	r.audit(auditUpdate, name, before, after, reason)
+
+	return nil
+}
+
+// nodePatch builds a json merge patch from the changes to the flattened node fields, a field which has been
+// removed is set to null so the patch removes it from the node; the resource version is a precondition if given
+func nodePatch(changes map[string]*fieldChange, resourceVersion string) ([]byte, error) {
+	labels := make(map[string]interface{}, 0)
+	annotations := make(map[string]interface{}, 0)
+	spec := make(map[string]interface{}, 0)
+	for field, change := range changes {
+		var value interface{}
+		if change.After != nil {
+			value = *change.After
+		}
+		path := strings.SplitN(field, "/", 2)
+		if len(path) != 2 {
+			return nil, fmt.Errorf("invalid node field: %s", field)
+		}
+		switch path[0] {
+		case "labels":
+			labels[path[1]] = value
+		case "annotations":
+			annotations[path[1]] = value
+		case "spec":
+			switch path[1] {
+			case "unschedulable":
+				spec[path[1]] = change.After != nil && *change.After == "true"
+			case "taints":
+				list := make([]interface{}, 0)
+				if change.After != nil {
+					if err := json.Unmarshal([]byte(*change.After), &list); err != nil {
+						return nil, fmt.Errorf("invalid taints: %s, error: %s", *change.After, err)
+					}
+				}
+				spec[path[1]] = list
+			default:
+				return nil, fmt.Errorf("unsupported node field: %s", field)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported node field: %s", field)
+		}
+	}
+
+	patch := make(map[string]interface{}, 0)
+	metadata := make(map[string]interface{}, 0)
+	if len(labels) > 0 {
+		metadata["labels"] = labels
+	}
+	if len(annotations) > 0 {
+		metadata["annotations"] = annotations
+	}
+	if resourceVersion != "" {
+		metadata["resourceVersion"] = resourceVersion
+	}
+	if len(metadata) > 0 {
+		patch["metadata"] = metadata
+	}
+	if len(spec) > 0 {
+		patch["spec"] = spec
+	}
+
+	return json.Marshal(patch)
 }
 
 // RegisterNode register a node with kubernetes
-func (r KubernetesInterface) RegisterNode(machine *Machine) error {
-	glog.V(4).Infof("Registering the machine: %s with kubernetes api", machine)
+func (r KubernetesInterface) RegisterNode(ctx context.Context, machine *Machine, reason string) error {
+	glog.V(4).Infof("Registering the machine: %s with kubernetes api", machine.Name)
+
+	// step: check the node is not registered already, in a dry run a preceding delete was not made
+	if !r.dryRun {
+		if _, found, err := r.IsRegistered(ctx, machine.Name); err != nil {
+			return err
+		} else if found {
+			return fmt.Errorf("the node: %s is already registered, refusing to create it", machine.Name)
+		}
+	}
 
-	// step: check the node is not registered already
-	if _, found, err := r.IsRegistered(machine.Name); err != nil {
+	// step: construct and register the new kubernetes node
+	node, taints := r.newNode(machine)
+	if err := r.createNode(ctx, node, taints, reason); err != nil {
 		return err
-	} else if found {
-		return fmt.Errorf("the node: %s is already registered, refusing to create it", machine.Name)
 	}
 
-	// step: construct the new kubernetes node
+	glog.V(3).Infof("Successfully registered the node: %s with kubernetes", machine.Name)
+	return nil
+}
+
+// RestoreNode recreates a node we have deleted along with its spec taints, used to roll back a failed replacement of the node
+func (r KubernetesInterface) RestoreNode(ctx context.Context, node *api.Node, taints *nodeTaints, reason string) error {
+	glog.V(3).Infof("Restoring the node: %s in kubernetes, reason: %s", node.Name, reason)
+	restored := *node
+	// step: the server assigned fields must be cleared for the node to be created
+	restored.ObjectMeta = api.ObjectMeta{
+		Name:        node.Name,
+		Labels:      node.Labels,
+		Annotations: node.Annotations,
+	}
+
+	return r.createNode(ctx, &restored, taints, reason)
+}
+
+// GetTaints retrieves the taints from the spec of the node, which the vendored api does not decode
+func (r KubernetesInterface) GetTaints(ctx context.Context, name string) (*nodeTaints, error) {
+	kapi, err := r.kubeClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	content, err := kapi.Get().Resource("nodes").Name(name).Do().Raw()
+	if err != nil {
+		return nil, err
+	}
+	var node struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+		Spec struct {
+			Taints []map[string]interface{} `json:"taints"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(content, &node); err != nil {
+		return nil, fmt.Errorf("unable to decode the node: %s, error: %s", name, err)
+	}
+
+	return &nodeTaints{resourceVersion: node.Metadata.ResourceVersion, list: node.Spec.Taints}, nil
+}
+
+// createNode creates the node in kubernetes, adding the spec taints if given
+func (r KubernetesInterface) createNode(ctx context.Context, node *api.Node, taints *nodeTaints, reason string) error {
+	if r.dryRun {
+		glog.Infof("[dry-run] skipping the creation of the node: %s", node.Name)
+		return nil
+	}
+	if err := r.checkAudit(auditCreate, node.Name); err != nil {
+		return err
+	}
+	kapi, err := r.kubeClient(ctx)
+	if err != nil {
+		return err
+	}
+	span := cycleTracer.Start("kubernetes.create", "cluster", r.cluster, "node", node.Name)
+	if taints != nil && len(taints.list) > 0 {
+		err = r.createNodeWithTaints(kapi, node, taints)
+	} else {
+		_, err = kapi.Nodes().Create(node)
+	}
+	span.End(err)
+	if err != nil {
+		return err
+	}
+	r.audit(auditCreate, node.Name, nil, node, reason)
+
+	return nil
+}
+
+// createNodeWithTaints creates the node with the taints added to the spec, the vendored api predating the field
+func (r KubernetesInterface) createNodeWithTaints(kapi *client.Client, node *api.Node, taints *nodeTaints) error {
+	content, err := kapi.Codec.Encode(node)
+	if err != nil {
+		return fmt.Errorf("unable to encode the node: %s, error: %s", node.Name, err)
+	}
+	var encoded map[string]interface{}
+	if err := json.Unmarshal(content, &encoded); err != nil {
+		return fmt.Errorf("unable to decode the node: %s, error: %s", node.Name, err)
+	}
+	spec, _ := encoded["spec"].(map[string]interface{})
+	if spec == nil {
+		spec = make(map[string]interface{}, 0)
+		encoded["spec"] = spec
+	}
+	spec["taints"] = taints.list
+	if content, err = json.Marshal(encoded); err != nil {
+		return fmt.Errorf("unable to encode the node: %s, error: %s", node.Name, err)
+	}
+
+	return kapi.Post().Resource("nodes").Body(content).Do().Error()
+}
+
+// newNode constructs the kubernetes node for the machine, along with the spec taints if the cluster reads them from the spec
+func (r KubernetesInterface) newNode(machine *Machine) (*api.Node, *nodeTaints) {
 	node := new(api.Node)
 	node.Name = machine.Name
 	node.ObjectMeta.Name = machine.Name
-	node.APIVersion = config.kubeVersion
-	node.Labels = machine.Metadata
+	node.APIVersion = r.version
+	node.Labels = make(map[string]string, 0)
+	node.Annotations = make(map[string]string, 0)
 	node.Spec.ExternalID = machine.Name
+	// step: the machine id is a stable identity, the ip address may be reused
+	if machine.ID != "" {
+		node.Spec.ExternalID = machine.ID
+	}
+	// step: apply the identity, annotations and spec fields from the machine
+	var taints *nodeTaints
+	if r.specTaints {
+		taints = &nodeTaints{}
+	}
+	applyMachine(node, machine, taints)
+	// step: publish the addresses of the node
+	node.Status.Addresses = nodeAddresses(machine)
+	// step: populate the capacity from the machine spec until the kubelet posts its status
+	if machine.Spec != nil {
+		node.Status.Capacity = nodeCapacity(machine.Spec)
+		node.Status.NodeInfo = nodeSystemInfo(machine.Spec)
+	}
 
-	// step: register the node with kubernetes
-	if _, err := r.client.Nodes().Create(node); err != nil {
-		return err
+	return node, taints
+}
+
+// checkAudit refuses a change to the node which we would be unable to record in the audit log
+func (r KubernetesInterface) checkAudit(action, name string) error {
+	if auditLog == nil {
+		return nil
+	}
+	if err := auditLog.Check(); err != nil {
+		return fmt.Errorf("refusing to %s the node: %s, the change cannot be recorded in the audit log, error: %s", action, name, err)
 	}
 
-	glog.V(3).Infof("Successfully registered the node: %s with kubernetes", node.Name)
 	return nil
 }
+
+// auditState retrieves the node prior to a change, if we are keeping an audit log
+func (r KubernetesInterface) auditState(ctx context.Context, name string) *api.Node {
+	if auditLog == nil {
+		return nil
+	}
+	kapi, err := r.nodes(ctx)
+	if err != nil {
+		glog.Warningf("Unable to retrieve the node: %s for the audit log, error: %s", name, err)
+		return nil
+	}
+	node, err := kapi.Get(name)
+	if err != nil {
+		glog.Warningf("Unable to retrieve the node: %s for the audit log, error: %s", name, err)
+		return nil
+	}
+
+	return node
+}
+
+// audit records the change to the node in the audit log
+func (r KubernetesInterface) audit(action, name string, before, after *api.Node, reason string) {
+	if auditLog == nil {
+		return
+	}
+	entry := &auditEntry{
+		Timestamp: time.Now().UTC(),
+		Action:    action,
+		Cluster:   r.cluster,
+		Node:      name,
+		Reason:    reason,
+	}
+	for _, node := range []*api.Node{before, after} {
+		if node != nil && node.Annotations[annotationMachineID] != "" {
+			entry.MachineID = node.Annotations[annotationMachineID]
+		}
+	}
+	if before != nil {
+		entry.BeforeLabels = before.Labels
+	}
+	if after != nil {
+		entry.AfterLabels = after.Labels
+	}
+	if err := auditLog.Record(entry); err != nil {
+		glog.Errorf("Failed to record the %s of the node: %s in the audit log, error: %s", action, name, err)
+	}
+}
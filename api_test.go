@@ -0,0 +1,170 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/client"
+)
+
+func TestNodePatch(t *testing.T) {
+	before := nodeFields(&api.Node{
+		ObjectMeta: api.ObjectMeta{
+			Labels:      map[string]string{"role": "kubernetes", "zone": "eu1", "kubernetes.io/hostname": "worker-1"},
+			Annotations: map[string]string{"node-register/labels": "role,zone"},
+		},
+	}, nil)
+	after := nodeFields(&api.Node{
+		ObjectMeta: api.ObjectMeta{
+			Labels:      map[string]string{"role": "worker", "kubernetes.io/hostname": "worker-1"},
+			Annotations: map[string]string{"node-register/labels": "role", "node-register/unschedulable": "true"},
+		},
+		Spec: api.NodeSpec{Unschedulable: true},
+	}, nil)
+	content, err := nodePatch(diffFields(before, after), "")
+	if err != nil {
+		t.Fatalf("unable to build the patch, error: %s", err)
+	}
+	var patch map[string]interface{}
+	if err := json.Unmarshal(content, &patch); err != nil {
+		t.Fatalf("unable to decode the patch, error: %s", err)
+	}
+	expected := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": map[string]interface{}{"role": "worker", "zone": nil},
+			"annotations": map[string]interface{}{
+				"node-register/labels":        "role",
+				"node-register/unschedulable": "true",
+			},
+		},
+		"spec": map[string]interface{}{"unschedulable": true},
+	}
+	if !reflect.DeepEqual(patch, expected) {
+		t.Errorf("expected the patch: %v, got: %v", expected, patch)
+	}
+
+	// step: a field we do not manage is refused
+	if _, err := nodePatch(map[string]*fieldChange{"spec/podCIDR": {}}, ""); err == nil {
+		t.Errorf("expected an error for an unsupported field")
+	}
+}
+
+func TestUpdateNodePatch(t *testing.T) {
+	var method, path, contentType string
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path, contentType = r.Method, r.URL.Path, r.Header.Get("Content-Type")
+		body, _ = ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"kind": "Node", "apiVersion": "v1", "metadata": {"name": "10.0.0.1", "labels": {"role": "worker"}}}`))
+	}))
+	defer server.Close()
+	cycleTracer = NewCycleTracer(1, "")
+
+	kapi := KubernetesInterface{
+		kubecfg: client.Config{Host: server.URL, Version: "v1"},
+		cluster: "default",
+	}
+	worker := "worker"
+	changes := map[string]*fieldChange{"labels/role": {After: &worker}}
+	if err := kapi.UpdateNode(context.Background(), "10.0.0.1", changes, nil, "testing"); err != nil {
+		t.Fatalf("unable to update the node, error: %s", err)
+	}
+	if method != "PATCH" || path != "/api/v1/nodes/10.0.0.1" {
+		t.Errorf("expected a patch of the node, got: %s %s", method, path)
+	}
+	if contentType != string(api.MergePatchType) {
+		t.Errorf("expected a merge patch, got: %s", contentType)
+	}
+	if string(body) != `{"metadata":{"labels":{"role":"worker"}}}` {
+		t.Errorf("unexpected patch: %s", body)
+	}
+}
+
+func TestNodePatchTaints(t *testing.T) {
+	before := nodeFields(&api.Node{}, &nodeTaints{list: []map[string]interface{}{{"key": "other", "effect": "NoSchedule"}}})
+	after := nodeFields(&api.Node{}, &nodeTaints{list: []map[string]interface{}{
+		{"key": "other", "effect": "NoSchedule"},
+		{"key": "spot", "value": "true", "effect": "NoExecute"},
+	}})
+	content, err := nodePatch(diffFields(before, after), "42")
+	if err != nil {
+		t.Fatalf("unable to build the patch, error: %s", err)
+	}
+	expected := `{"metadata":{"resourceVersion":"42"},"spec":{"taints":[{"effect":"NoSchedule","key":"other"},{"effect":"NoExecute","key":"spot","value":"true"}]}}`
+	if string(content) != expected {
+		t.Errorf("expected the patch: %s, got: %s", expected, content)
+	}
+}
+
+func TestSpecTaints(t *testing.T) {
+	var method, path string
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path = r.Method, r.URL.Path
+		if r.Method == "POST" {
+			json.NewDecoder(r.Body).Decode(&body)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"kind": "Node", "apiVersion": "v1", "metadata": {"name": "10.0.0.1", "resourceVersion": "42"},
+			"spec": {"taints": [{"key": "other", "effect": "NoExecute", "timeAdded": "2017-01-01T00:00:00Z"}]}}`))
+	}))
+	defer server.Close()
+	cycleTracer = NewCycleTracer(1, "")
+
+	kapi := KubernetesInterface{
+		kubecfg:    client.Config{Host: server.URL, Version: "v1"},
+		cluster:    "default",
+		specTaints: true,
+	}
+	taints, err := kapi.GetTaints(context.Background(), "10.0.0.1")
+	if err != nil {
+		t.Fatalf("unable to retrieve the taints, error: %s", err)
+	}
+	if taints.resourceVersion != "42" || len(taints.list) != 1 || taints.list[0]["timeAdded"] != "2017-01-01T00:00:00Z" {
+		t.Errorf("unexpected taints: %v", taints)
+	}
+
+	// step: the taints are placed in the spec of a new node
+	node, taints := kapi.newNode(&Machine{
+		Name:     "10.0.0.1",
+		Metadata: map[string]string{},
+		Taints:   []nodeTaint{{Key: "spot", Value: "true", Effect: "NoExecute"}},
+	})
+	if err := kapi.createNode(context.Background(), node, taints, "testing"); err != nil {
+		t.Fatalf("unable to create the node, error: %s", err)
+	}
+	if method != "POST" || path != "/api/v1/nodes" {
+		t.Errorf("expected the node to be created, got: %s %s", method, path)
+	}
+	spec, _ := body["spec"].(map[string]interface{})
+	expected := []interface{}{map[string]interface{}{"key": "spot", "value": "true", "effect": "NoExecute"}}
+	if !reflect.DeepEqual(spec["taints"], expected) {
+		t.Errorf("expected the taints: %v in the spec, got: %v", expected, spec["taints"])
+	}
+	if spec["externalID"] != "10.0.0.1" {
+		t.Errorf("expected the rest of the spec to be kept, got: %v", spec)
+	}
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// the node was created
+	auditCreate = "create"
+	// the node was updated
+	auditUpdate = "update"
+	// the node was deleted
+	auditDelete = "delete"
+)
+
+// auditEntry ... a line in the audit log
+type auditEntry struct {
+	// the time of the action
+	Timestamp time.Time `json:"timestamp"`
+	// the action, create, update or delete
+	Action string `json:"action"`
+	// the cluster the node is in
+	Cluster string `json:"cluster"`
+	// the name of the node
+	Node string `json:"node"`
+	// the id of the machine
+	MachineID string `json:"machine_id,omitempty"`
+	// the labels before the action
+	BeforeLabels map[string]string `json:"before_labels"`
+	// the labels after the action
+	AfterLabels map[string]string `json:"after_labels"`
+	// the reason for the action
+	Reason string `json:"reason"`
+	// the instance of the service which made the change
+	Instance string `json:"instance"`
+}
+
+// NewAuditLog opens the audit log, appending to any existing file
+func NewAuditLog(filename string, maxSize int64, maxBackups int) (*AuditLog, error) {
+	glog.V(3).Infof("Opening the audit log: %s, max size: %d, max backups: %d", filename, maxSize, maxBackups)
+	audit := &AuditLog{
+		filename:   filename,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := audit.open(); err != nil {
+		return nil, err
+	}
+
+	return audit, nil
+}
+
+// Record writes the entry to the audit log, rotating the file if it has reached the maximum size
+func (r *AuditLog) Record(entry *auditEntry) error {
+	entry.Instance = config.instanceID
+	content, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	content = append(content, '\n')
+
+	r.Lock()
+	defer r.Unlock()
+	if err := r.reopen(); err != nil {
+		return err
+	}
+	if r.maxSize > 0 && r.size+int64(len(content)) > r.maxSize && r.size > 0 {
+		if err := r.rotate(); err != nil {
+			return err
+		}
+	}
+	written, err := r.file.Write(content)
+	r.size += int64(written)
+	if err != nil {
+		return fmt.Errorf("unable to write to the audit log: %s, error: %s", r.filename, err)
+	}
+
+	return nil
+}
+
+// Check ensures the audit log can be written to, reopening it if required, so a change can be refused
+// rather than made without a record of it
+func (r *AuditLog) Check() error {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.reopen()
+}
+
+// Close flushes and closes the audit log, any later entries are refused
+func (r *AuditLog) Close() error {
+	r.Lock()
+	defer r.Unlock()
+	r.closed = true
+	if r.file == nil {
+		return nil
+	}
+	glog.V(3).Infof("Closing the audit log: %s", r.filename)
+	file := r.file
+	r.file = nil
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("unable to flush the audit log: %s, error: %s", r.filename, err)
+	}
+
+	return file.Close()
+}
+
+// open opens the audit log in append only mode
+func (r *AuditLog) open() error {
+	file, err := os.OpenFile(r.filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to open the audit log: %s, error: %s", r.filename, err)
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("unable to stat the audit log: %s, error: %s", r.filename, err)
+	}
+	r.file = file
+	r.size = stat.Size()
+
+	return nil
+}
+
+// reopen opens the audit log if a previous failure left it closed, unless it was closed on shutdown
+func (r *AuditLog) reopen() error {
+	if r.closed {
+		return fmt.Errorf("the audit log: %s has been closed", r.filename)
+	}
+	if r.file != nil {
+		return nil
+	}
+	glog.V(3).Infof("Reopening the audit log: %s", r.filename)
+
+	return r.open()
+}
+
+// rotate rotates the audit log and opens a new one; if the rotation fails we reopen and continue appending to
+// the current log, the rotation being retried on the next entry
+func (r *AuditLog) rotate() error {
+	glog.V(3).Infof("Rotating the audit log: %s, size: %d", r.filename, r.size)
+	err := r.file.Close()
+	r.file = nil
+	if err != nil {
+		err = fmt.Errorf("unable to close the audit log: %s, error: %s", r.filename, err)
+	} else {
+		err = r.backup()
+	}
+	if err != nil {
+		glog.Errorf("Failed to rotate the audit log, %s", err)
+	}
+
+	return r.open()
+}
+
+// backup moves the audit log to a numbered backup, i.e. audit.log.1, discarding the oldest backup
+func (r *AuditLog) backup() error {
+	if r.maxBackups > 0 {
+		for i := r.maxBackups - 1; i > 0; i-- {
+			backup := fmt.Sprintf("%s.%d", r.filename, i)
+			if _, err := os.Stat(backup); err == nil {
+				if err := os.Rename(backup, fmt.Sprintf("%s.%d", r.filename, i+1)); err != nil {
+					return fmt.Errorf("unable to rotate the audit log: %s, error: %s", backup, err)
+				}
+			}
+		}
+		if err := os.Rename(r.filename, r.filename+".1"); err != nil {
+			return fmt.Errorf("unable to rotate the audit log: %s, error: %s", r.filename, err)
+		}
+	} else if err := os.Remove(r.filename); err != nil {
+		return fmt.Errorf("unable to remove the audit log: %s, error: %s", r.filename, err)
+	}
+
+	return nil
+}
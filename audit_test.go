@@ -0,0 +1,114 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// readAuditLog reads the entries from the audit log
+func readAuditLog(t *testing.T, filename string) []*auditEntry {
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatalf("unable to open the audit log: %s, error: %s", filename, err)
+	}
+	defer file.Close()
+	var entries []*auditEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		entry := new(auditEntry)
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			t.Fatalf("invalid audit entry: %s, error: %s", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+func TestAuditLogRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatalf("unable to create a temporary directory, error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "audit.log")
+
+	audit, err := NewAuditLog(filename, 200, 2)
+	if err != nil {
+		t.Fatalf("unable to open the audit log, error: %s", err)
+	}
+	for _, node := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		if err := audit.Record(&auditEntry{Action: auditCreate, Node: node}); err != nil {
+			t.Fatalf("unable to record the entry, error: %s", err)
+		}
+	}
+	if entries := readAuditLog(t, filename); len(entries) != 1 || entries[0].Node != "10.0.0.3" {
+		t.Errorf("expected only the last entry in the audit log, got: %v", entries)
+	}
+	if entries := readAuditLog(t, filename+".2"); len(entries) != 1 || entries[0].Node != "10.0.0.1" {
+		t.Errorf("expected the first entry in the oldest backup, got: %v", entries)
+	}
+
+	// step: a failed rotation continues appending to the current log
+	os.Remove(filename + ".1")
+	if err := os.MkdirAll(filepath.Join(filename+".1", "blocked"), 0700); err != nil {
+		t.Fatalf("unable to create the directory, error: %s", err)
+	}
+	if err := audit.Record(&auditEntry{Action: auditUpdate, Node: "10.0.0.4"}); err != nil {
+		t.Fatalf("expected the entry to be recorded despite the failed rotation, error: %s", err)
+	}
+	if entries := readAuditLog(t, filename); len(entries) != 2 || entries[1].Node != "10.0.0.4" {
+		t.Errorf("expected the entry to be appended to the current log, got: %v", entries)
+	}
+	os.RemoveAll(filename + ".1")
+
+	// step: a log which cannot be opened is refused until it can be reopened
+	audit.Lock()
+	audit.file.Close()
+	audit.file = nil
+	audit.Unlock()
+	os.RemoveAll(dir)
+	if err := audit.Check(); err == nil {
+		t.Errorf("expected the check to fail when the audit log cannot be opened")
+	}
+	if err := audit.Record(&auditEntry{Action: auditDelete, Node: "10.0.0.5"}); err == nil {
+		t.Errorf("expected the entry to be refused when the audit log cannot be opened")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatalf("unable to create the directory, error: %s", err)
+	}
+	if err := audit.Record(&auditEntry{Action: auditDelete, Node: "10.0.0.5"}); err != nil {
+		t.Errorf("expected the audit log to be reopened, error: %s", err)
+	}
+
+	// step: once closed the log refuses any entries
+	if err := audit.Close(); err != nil {
+		t.Fatalf("unable to close the audit log, error: %s", err)
+	}
+	if err := audit.Check(); err == nil {
+		t.Errorf("expected the check to fail once the audit log is closed")
+	}
+	if err := audit.Record(&auditEntry{Action: auditDelete, Node: "10.0.0.6"}); err == nil {
+		t.Errorf("expected the entry to be refused once the audit log is closed")
+	}
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+)
+
+// loadClusters ... reads in the cluster targets from the yaml or json clusters file
+func loadClusters(filename string) ([]*ClusterTarget, error) {
+	glog.V(4).Infof("Reading in the cluster targets from file: %s", filename)
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the clusters file: %s, error: %s", filename, err)
+	}
+
+	var clusters struct {
+		Clusters []*ClusterTarget `json:"clusters"`
+	}
+	if err := yaml.Unmarshal(content, &clusters); err != nil {
+		return nil, fmt.Errorf("unable to decode the clusters file: %s, error: %s", filename, err)
+	}
+	if len(clusters.Clusters) <= 0 {
+		return nil, fmt.Errorf("the clusters file: %s does not contain any clusters", filename)
+	}
+
+	names := make(map[string]bool, 0)
+	for index, cluster := range clusters.Clusters {
+		// check: each cluster must have a unique name
+		if cluster.Name == "" {
+			return nil, fmt.Errorf("the cluster at index %d does not have a name", index)
+		}
+		if names[cluster.Name] {
+			return nil, fmt.Errorf("the cluster: %s is defined more than once", cluster.Name)
+		}
+		names[cluster.Name] = true
+		// step: fill in any defaults from the command line options
+		if cluster.APIVersion == "" {
+			cluster.APIVersion = config.kubeVersion
+		}
+		if cluster.Metadata == "" {
+			cluster.Metadata = config.metadata
+		}
+		if err := validateCluster(cluster); err != nil {
+			return nil, err
+		}
+	}
+
+	return clusters.Clusters, nil
+}
+
+// defaultCluster ... creates a cluster target from the command line options
+func defaultCluster() (*ClusterTarget, error) {
+	cluster := &ClusterTarget{
+		Name:       "default",
+		API:        config.kubeAPI,
+		APIVersion: config.kubeVersion,
+		Token:      config.kubeToken,
+		TokenFile:  config.kubeTokenFile,
+		Cert:       config.kubeCert,
+		Insecure:   config.kubeInsecure,
+		Metadata:   config.metadata,
+	}
+
+	return cluster, validateCluster(cluster)
+}
+
+// validateCluster ... checks the cluster options and parses the metadata selector
+func validateCluster(cluster *ClusterTarget) error {
+	var err error
+	// check: ensure the url is valid
+	if cluster.API == "" {
+		return fmt.Errorf("the cluster: %s does not have a kubernetes api endpoint", cluster.Name)
+	}
+	if _, err := url.Parse(cluster.API); err != nil {
+		return fmt.Errorf("invalid url for the cluster: %s kubernetes api, error: %s", cluster.Name, err)
+	}
+	// check: ensure the token file exists
+	if cluster.TokenFile != "" {
+		if _, err := os.Stat(cluster.TokenFile); os.IsNotExist(err) {
+			return fmt.Errorf("the token file: %s for cluster: %s does not exist", cluster.TokenFile, cluster.Name)
+		}
+	}
+	// check: ensure the cert exists
+	if cluster.Cert != "" {
+		if _, err := os.Stat(cluster.Cert); os.IsNotExist(err) {
+			return fmt.Errorf("the kube cert file: %s for cluster: %s does not exist", cluster.Cert, cluster.Name)
+		}
+	}
+	// step: parse the metadata selector
+	if cluster.selector, err = parseSelector(cluster.Metadata); err != nil {
+		return fmt.Errorf("invalid metadata for cluster: %s, %s", cluster.Name, err)
+	}
+
+	return nil
+}
+
+// routeMachine ... finds the first cluster whose metadata selector matches the machine
+func routeMachine(machine *Machine) *ClusterTarget {
+	for _, cluster := range clusters {
+		if cluster.Matches(machine) {
+			glog.V(5).Infof("Routing the machine: %s to the cluster: %s", machine.Name, cluster.Name)
+			return cluster
+		}
+	}
+
+	return nil
+}
+
+// Matches ... checks the machine metadata satisfies the cluster selector
+func (r ClusterTarget) Matches(machine *Machine) bool {
+	for name, value := range r.selector {
+		// step: does the tag exist in the metadata
+		tag, found := machine.Metadata[name]
+		if !found {
+			glog.V(5).Infof("Skipping machine: '%s' for cluster: %s, does not have '%s' tag in metadata",
+				machine.Name, r.Name, name)
+			return false
+		}
+		// step: is the value of the tag correct?
+		if tag != value {
+			glog.V(5).Infof("Skipping machine: %s for cluster: %s, machine tag value: '%s' not equal to '%s'",
+				machine.Name, r.Name, tag, value)
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,253 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+// the subcommands and the number of arguments they take
+var commands = map[string]int{
+	"run":        0,
+	"once":       0,
+	"plan":       0,
+	"machines":   0,
+	"nodes":      0,
+	"register":   1,
+	"deregister": 1,
+	"reap":       0,
+}
+
+// machineView ... a machine in the output of the machines command
+type machineView struct {
+	// the ip address of the machine
+	Address string `json:"address"`
+	// the machine id
+	ID string `json:"id,omitempty"`
+	// the sources which reported the machine
+	Sources []string `json:"sources"`
+	// the metadata of the machine
+	Metadata map[string]string `json:"metadata"`
+	// the cluster the machine is routed to
+	Cluster string `json:"cluster,omitempty"`
+	// whether the machine passes the filters of a cluster
+	Matched bool `json:"matched"`
+}
+
+// nodeView ... a node in the output of the nodes command
+type nodeView struct {
+	// the cluster the node is in
+	Cluster string `json:"cluster"`
+	// the name of the node
+	Name string `json:"name"`
+	// the status of the ready condition
+	Ready string `json:"ready"`
+	// the time since the last heartbeat
+	HeartbeatAge string `json:"heartbeat_age,omitempty"`
+	// whether the node was registered by us
+	Managed bool `json:"managed"`
+}
+
+// runCommand runs the subcommand requested on the command line
+func runCommand(ctx context.Context, source MachineSource) error {
+	switch config.command {
+	case "", "run":
+		runDaemon(ctx, source)
+	case "once":
+		code := runOnce(ctx, source)
+		shutdown()
+		os.Exit(code)
+	case "plan":
+		syncMachines(ctx, source)
+		return syncPlan.Write(os.Stdout, config.outputFormat)
+	case "machines":
+		return listMachines(ctx, os.Stdout, source)
+	case "nodes":
+		return listNodes(ctx, os.Stdout)
+	case "register":
+		return registerAddress(ctx, source, config.commandArgs[0])
+	case "deregister":
+		return deregisterNode(ctx, config.commandArgs[0])
+	case "reap":
+		return reapClusters(ctx)
+	}
+
+	return nil
+}
+
+// listMachines writes the machines from the source along with the cluster they are routed to
+func listMachines(ctx context.Context, w io.Writer, source MachineSource) error {
+	machines, err := source.GetMachines(ctx)
+	if _, found := isPartialError(err); found {
+		glog.Warningf("Only some of the machines could be retrieved, error: %s", err)
+	} else if err != nil {
+		return fmt.Errorf("unable to retrieve the machines, error: %s", err)
+	}
+	var list []*machineView
+	for _, machine := range machines {
+		view := &machineView{
+			Address:  machine.Name,
+			ID:       machine.ID,
+			Sources:  machine.Sources,
+			Metadata: machine.Metadata,
+		}
+		if cluster := routeMachine(machine); cluster != nil {
+			view.Cluster = cluster.Name
+			view.Matched = true
+		}
+		list = append(list, view)
+	}
+
+	if config.outputFormat == "json" {
+		return writeIndented(w, list)
+	}
+	writer := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(writer, "ADDRESS\tID\tSOURCES\tCLUSTER\tMATCHED\tMETADATA")
+	for _, x := range list {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%t\t%s\n", x.Address, x.ID, strings.Join(x.Sources, ","),
+			x.Cluster, x.Matched, formatMetadata(x.Metadata))
+	}
+
+	return writer.Flush()
+}
+
+// listNodes writes the nodes in each of the clusters along with their ready condition
+func listNodes(ctx context.Context, w io.Writer) error {
+	var list []*nodeView
+	for _, cluster := range clusters {
+		nodes, err := cluster.kapi.GetNodes(ctx)
+		if err != nil {
+			return fmt.Errorf("unable to retrieve the nodes from cluster: %s, error: %s", cluster.Name, err)
+		}
+		for _, node := range nodes {
+			view := &nodeView{
+				Cluster: cluster.Name,
+				Name:    node.Name,
+				Ready:   string(api.ConditionUnknown),
+			}
+			_, view.Managed = node.Annotations[annotationSources]
+			for _, condition := range node.Status.Conditions {
+				if condition.Type == api.NodeReady {
+					view.Ready = string(condition.Status)
+					age := time.Since(condition.LastHeartbeatTime.Time)
+					view.HeartbeatAge = (age - age%time.Second).String()
+				}
+			}
+			list = append(list, view)
+		}
+	}
+
+	if config.outputFormat == "json" {
+		return writeIndented(w, list)
+	}
+	writer := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(writer, "CLUSTER\tNAME\tREADY\tHEARTBEAT\tMANAGED")
+	for _, x := range list {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%t\n", x.Cluster, x.Name, x.Ready, x.HeartbeatAge, x.Managed)
+	}
+
+	return writer.Flush()
+}
+
+// registerAddress registers the machine with the address in the cluster it is routed to
+func registerAddress(ctx context.Context, source MachineSource, address string) error {
+	machine, err := getMachine(ctx, source, address)
+	if err != nil {
+		return err
+	}
+	cluster := routeMachine(machine)
+	if cluster == nil {
+		return fmt.Errorf("the machine: %s does not match any of the clusters", address)
+	}
+	verdict, err := registerMachine(ctx, cluster, machine)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("machine: %s, cluster: %s, node: %s, result: %s\n", address, cluster.Name, machine.Name, verdict)
+
+	return nil
+}
+
+// deregisterNode deletes the node from any of the clusters it is registered in
+func deregisterNode(ctx context.Context, name string) error {
+	found := false
+	for _, cluster := range clusters {
+		_, registered, err := cluster.kapi.IsRegistered(ctx, name)
+		if err != nil {
+			return fmt.Errorf("unable to check the node in cluster: %s, error: %s", cluster.Name, err)
+		}
+		if !registered {
+			continue
+		}
+		found = true
+		if err := cluster.kapi.DeleteNode(ctx, name, "deregistered by an operator"); err != nil {
+			return fmt.Errorf("unable to delete the node: %s from cluster: %s, error: %s", name, cluster.Name, err)
+		}
+		glog.V(3).Infof("Deregistered the node: %s from cluster: %s", name, cluster.Name)
+		recordAction(&plannedAction{Action: actionDeregister, Cluster: cluster.Name, Node: name, Reason: "deregistered by an operator"})
+		fmt.Printf("node: %s, cluster: %s, result: deregistered\n", name, cluster.Name)
+	}
+	if !found {
+		return fmt.Errorf("the node: %s is not registered in any of the clusters", name)
+	}
+
+	return nil
+}
+
+// reapClusters reaps the failed nodes in each of the clusters and writes the nodes reaped
+func reapClusters(ctx context.Context) error {
+	for _, cluster := range clusters {
+		if err := reapNodes(ctx, cluster); err != nil {
+			return fmt.Errorf("unable to reap the nodes in cluster: %s, error: %s", cluster.Name, err)
+		}
+	}
+
+	return syncPlan.Write(os.Stdout, config.outputFormat)
+}
+
+// formatMetadata formats the metadata as a sorted, comma separated list of key=value
+func formatMetadata(metadata map[string]string) string {
+	var list []string
+	for key, value := range metadata {
+		list = append(list, key+"="+value)
+	}
+	sort.Strings(list)
+
+	return strings.Join(list, ",")
+}
+
+// writeIndented writes the value as indented json
+func writeIndented(w io.Writer, value interface{}) error {
+	content, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(content))
+
+	return err
+}
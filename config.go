@@ -19,11 +19,16 @@ package main
 import (
 	"flag"
 	"fmt"
-	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
+	"text/template"
 	"time"
+
+	"github.com/coreos/fleet/registry"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/util"
 )
 
 var config struct {
@@ -47,31 +52,184 @@ var config struct {
 	kubeNodeDowntime time.Duration
 	// resolve the dns
 	dnsResolve bool
+	// ensure the hostnames resolve back to the address
+	dnsConfirm bool
+	// the method used to select the hostname when there are multiple ptr records
+	dnsSelect string
+	// the time to cache the resolved hostnames
+	dnsCacheTTL time.Duration
+	// the policy when the address cannot be resolved, ip, skip or fail
+	dnsFallback string
+	// a template used to generate the node names
+	nodeNameTemplate string
+	// the parsed node name template
+	nameTemplate *template.Template
+	// the node address types to publish
+	nodeAddresses string
+	// the parsed node address types
+	nodeAddressTypes map[api.NodeAddressType]bool
+	// the address the status api listens on
+	listen string
+	// log the changes rather than making them
+	dryRun bool
+	// the output format of the plan, table or json
+	outputFormat string
+	// the file the report of a single pass is written to, - for stdout
+	reportFile string
+	// the path of the audit log
+	auditFile string
+	// the size in megabytes at which the audit log is rotated
+	auditMaxSize int64
+	// the number of rotated audit logs to keep
+	auditMaxBackups int
+	// the file containing the webhooks
+	webhooksFile string
+	// the address of the statsd endpoint
+	statsdAddress string
+	// the prefix added to the metric names
+	statsdPrefix string
+	// the tags added to every metric
+	statsdTags string
+	// whether the endpoint supports dogstatsd tags
+	dogstatsd bool
+	// the number of sync cycles kept for the status api
+	cycleHistory int
+	// the otlp/http collector the sync cycles are exported to
+	otlpEndpoint string
+	// write the actions to journald with structured fields
+	journald bool
+	// the identity of this instance of the service
+	instanceID string
+	// the subcommand being run
+	command string
+	// the arguments to the subcommand
+	commandArgs []string
+	// query the kubelet machine spec for the node capacity
+	kubeletSpec bool
+	// the capacity buckets used to derive the instance size
+	sizeBuckets string
+	// the parsed capacity buckets
+	capacityBuckets []capacityBucket
+	// the label the instance size is placed in
+	sizeLabel string
 	// the metadata used to filter the nodes
 	metadata string
+	// the source of the machines
+	machineSource string
+	// the list of machine sources, in order of precedence
+	machineSources []string
+	// the etcd endpoints used by fleet
+	etcdEndpoints string
+	// the key prefix fleet is using in etcd
+	etcdPrefix string
+	// the ca used to verify etcd
+	etcdCAFile string
+	// the client certificate for etcd
+	etcdCertFile string
+	// the client private key for etcd
+	etcdKeyFile string
+	// the timeout for requests to etcd
+	etcdTimeout time.Duration
+	// the static inventory file
+	inventoryFile string
+	// the interval to check the inventory file for changes
+	inventoryInterval time.Duration
+	// the dns srv record to discover the machines from
+	dnsSRV string
+	// a comma separated list of hostnames to discover the machines from
+	dnsHosts string
+	// a regex with named groups used to derive metadata from the hostnames
+	dnsPattern string
+	// static metadata added to the machines discovered in dns
+	dnsMetadata string
+	// a specific dns server to use
+	dnsServer string
+	// the timeout for dns lookups
+	dnsTimeout time.Duration
+	// the interval to check dns for changes
+	dnsInterval time.Duration
+	// the consul endpoint
+	consulAddress string
+	// the consul service used to filter the nodes
+	consulService string
+	// the consul service tag used to filter the nodes
+	consulTag string
+	// the consul datacenter
+	consulDatacenter string
+	// the consul acl token
+	consulToken string
+	// the maximum time to wait on a blocking query
+	consulWait time.Duration
+	// the timeout for listing the consul catalog
+	consulTimeout time.Duration
 	// the socket for fleet
 	fleetSocket string
+	// the ca used to verify the fleet api
+	fleetCAFile string
+	// the client certificate for the fleet api
+	fleetCertFile string
+	// the client private key for the fleet api
+	fleetKeyFile string
+	// the timeout for requests to the fleet api
+	fleetTimeout time.Duration
+	// the host to tunnel to fleet via
+	fleetTunnel string
+	// the ssh user for the tunnel
+	fleetSSHUser string
+	// the ssh private key for the tunnel
+	fleetSSHKey string
+	// the known hosts file used to verify the tunnel host key
+	fleetKnownHosts string
+	// refuse to tunnel to hosts which are not in the known hosts file
+	fleetStrictHostKeys bool
 	// the interface fleet is using as public ip
 	fleetInterface string
 	// the public ip address of fleet
 	fleetIPAddress string
 	// the interval to wait
 	timeInterval time.Duration
-	// the tag name
-	tagName string
-	// the tag value
-	tagValue string
+	// the maximum time to wait for the in-flight requests and flushes on shutdown
+	shutdownTimeout time.Duration
+	// a file containing the kubernetes clusters to register against
+	clustersFile string
 	// show version
 	showVersion bool
 	// standalone
 	standalone bool
 	// environment variables
 	labels map[string]string
+	// a prefix added to the label keys
+	labelPrefix string
+	// rename rules for the metadata keys
+	labelRenames string
+	// the metadata keys to include as labels
+	labelInclude string
+	// the metadata keys to exclude from the labels
+	labelExclude string
+	// lower case the label values
+	labelLowercase bool
+	// the metadata keys to map to annotations
+	annotationKeys string
+	// a prefix added to the annotation keys
+	annotationPrefix string
+	// the metadata which marks the node unschedulable
+	unschedulable string
+	// the rules mapping the metadata to node taints
+	taintRules string
 }
 
 const (
 	defaultSyncInterval   = time.Duration(60) * time.Second
 	defaultReaperInterval = time.Duration(1) * time.Hour
+	defaultFleetTimeout   = time.Duration(10) * time.Second
+	defaultEtcdTimeout    = time.Duration(5) * time.Second
+	defaultInventoryCheck = time.Duration(10) * time.Second
+	defaultDNSTimeout     = time.Duration(5) * time.Second
+	defaultDNSCheck       = time.Duration(30) * time.Second
+	defaultConsulWait     = time.Duration(5) * time.Minute
+	defaultConsulTimeout  = time.Duration(10) * time.Second
+	defaultDNSCacheTTL    = time.Duration(5) * time.Minute
+	defaultShutdown       = time.Duration(30) * time.Second
 )
 
 var (
@@ -85,9 +243,76 @@ func init() {
 	flag.StringVar(&config.kubeTokenFile, "token-file", "", "a file container a token to authenticate to kubernetes")
 	flag.BoolVar(&config.kubeInsecure, "insecure", false, "don't check the certifacte for the api")
 	flag.BoolVar(&config.dnsResolve, "dns-resolve", false, "resolve the ip addres into a dns name before registering")
+	flag.BoolVar(&config.dnsConfirm, "dns-confirm", false, "only use hostnames which forward resolve back to the ip address")
+	flag.StringVar(&config.dnsSelect, "dns-select", "first", "the ptr record to use when there are several, first, shortest, longest or sorted")
+	flag.DurationVar(&config.dnsCacheTTL, "dns-cache-ttl", defaultDNSCacheTTL, "the time to cache the resolved hostnames and failed lookups, zero disables the cache")
+	flag.StringVar(&config.dnsFallback, "dns-fallback", "fail", "the policy when an address cannot be resolved, ip (use the address), skip or fail")
+	flag.StringVar(&config.labelPrefix, "label-prefix", "", "a prefix added to the label keys mapped from the metadata, i.e. fleet.coreos.com/")
+	flag.StringVar(&config.labelRenames, "label-rename", "", "a comma separated list of metadata keys to rename, i.e. role=node-role")
+	flag.StringVar(&config.labelInclude, "label-include", "", "a comma separated list of metadata keys (globs) to include as labels, defaults to all")
+	flag.StringVar(&config.labelExclude, "label-exclude", "", "a comma separated list of metadata keys (globs) to exclude from the labels")
+	flag.BoolVar(&config.labelLowercase, "label-lowercase", false, "lower case the label values mapped from the metadata")
+	flag.StringVar(&config.annotationKeys, "annotation-keys", "", "a comma separated list of metadata keys (globs) mapped to annotations rather than labels")
+	flag.StringVar(&config.annotationPrefix, "annotation-prefix", "", "a prefix added to the annotation keys mapped from the metadata")
+	flag.StringVar(&config.unschedulable, "unschedulable", "", "metadata (tag=value,...) which marks the node as unschedulable, i.e. maintenance=true")
+	flag.StringVar(&config.taintRules, "taints", "", "a comma separated list of metadata mapped to node taints, i.e. spot=true:NoSchedule,gpu=*:NoExecute, placed in the node spec or the alpha taints annotation prior to kubernetes 1.6")
+	flag.StringVar(&config.nodeNameTemplate, "node-name-template", "", "a go template used to generate the node names, i.e. {{ .Metadata.role }}-{{ .ShortID }}")
+	flag.BoolVar(&config.dryRun, "dry-run", false, "perform the reconciliation and log the intended changes without making them")
+	flag.StringVar(&config.outputFormat, "output", "table", "the output format of the plan, table or json")
+	flag.StringVar(&config.reportFile, "report", "-", "the file the json report of the once command is written to, - for stdout")
+	flag.StringVar(&config.auditFile, "audit-log", "", "the path of a json lines audit log recording the changes made to the nodes, changes are refused while it cannot be written")
+	flag.Int64Var(&config.auditMaxSize, "audit-log-max-size", 100, "the size in megabytes at which the audit log is rotated")
+	flag.IntVar(&config.auditMaxBackups, "audit-log-max-backups", 5, "the number of rotated audit logs to keep")
+	flag.StringVar(&config.webhooksFile, "webhooks", "", "a yaml or json file containing the webhooks notified of the changes made to the nodes")
+	flag.StringVar(&config.statsdAddress, "statsd", "", "the address of a statsd endpoint to push the metrics to, i.e. 127.0.0.1:8125")
+	flag.StringVar(&config.statsdPrefix, "statsd-prefix", "node_register", "the prefix added to the names of the metrics")
+	flag.StringVar(&config.statsdTags, "statsd-tags", "", "a comma separated list of tags added to the metrics, i.e. env:prod, requires dogstatsd")
+	flag.BoolVar(&config.dogstatsd, "dogstatsd", false, "the statsd endpoint supports dogstatsd tags, the cluster and instance are added as tags")
+	flag.IntVar(&config.cycleHistory, "cycle-history", 20, "the number of sync cycle reports kept in memory for the status api")
+	flag.StringVar(&config.otlpEndpoint, "otlp-endpoint", "", "the otlp/http collector the sync cycle spans are exported to, i.e. http://127.0.0.1:4318")
+	flag.BoolVar(&config.journald, "journald", false, "write the actions taken against the nodes to journald with structured fields")
+	flag.StringVar(&config.instanceID, "instance-id", defaultInstanceID(), "the identity of this instance, recorded in the audit log")
+	flag.StringVar(&config.listen, "listen", "", "the address to serve the status api on, i.e. :8080, disabled when empty")
+	flag.BoolVar(&config.kubeletSpec, "kubelet-spec", false, "query the kubelet machine spec on the health port to populate the node capacity and system info")
+	flag.StringVar(&config.sizeBuckets, "size-buckets", "", "a comma separated list of minimum capacities used to derive the instance size label, i.e. small=1:2Gi,medium=4:8Gi,large=16:64Gi")
+	flag.StringVar(&config.sizeLabel, "size-label", "node-register/instance-size", "the label the instance size derived from the capacity buckets is placed in")
+	flag.StringVar(&config.nodeAddresses, "node-addresses", "InternalIP,ExternalIP,Hostname", "a comma separated list of the address types to publish on the node, InternalIP, ExternalIP and Hostname")
 	flag.StringVar(&config.kubeCert, "cert", "", "a client cerfiticate to use to authenticate with kubernetes")
 	flag.StringVar(&config.metadata, "metadata", "role=kubernetes", "the fleet metadata with are using to filter nodes")
-	flag.StringVar(&config.fleetSocket, "fleet", "unix://var/run/fleet.sock", "the path to the fleet unix socket")
+	flag.StringVar(&config.clustersFile, "clusters", "", "a yaml or json file containing multiple kubernetes clusters to route machines into")
+	flag.StringVar(&config.machineSource, "source", "fleet", "a comma separated list of machine sources in order of precedence, either fleet, etcd (reading the fleet registry directly), file, dns or consul")
+	flag.StringVar(&config.etcdEndpoints, "etcd-endpoints", "http://127.0.0.1:2379", "a comma separated list of etcd endpoints used by fleet")
+	flag.StringVar(&config.etcdPrefix, "etcd-prefix", registry.DefaultKeyPrefix, "the key prefix fleet is using in etcd")
+	flag.StringVar(&config.etcdCAFile, "etcd-ca-file", "", "a certificate authority used to verify etcd")
+	flag.StringVar(&config.etcdCertFile, "etcd-cert-file", "", "a client certificate used to authenticate to etcd")
+	flag.StringVar(&config.etcdKeyFile, "etcd-key-file", "", "the private key for the etcd client certificate")
+	flag.DurationVar(&config.etcdTimeout, "etcd-timeout", defaultEtcdTimeout, "the timeout for requests to etcd")
+	flag.StringVar(&config.inventoryFile, "inventory", "", "a yaml, json or csv inventory file containing the machines, used by the file source")
+	flag.DurationVar(&config.inventoryInterval, "inventory-interval", defaultInventoryCheck, "the interval to check the inventory file for changes")
+	flag.StringVar(&config.dnsSRV, "dns-srv", "", "a dns srv record to discover the machines from, i.e. _kubelet._tcp.cluster.local")
+	flag.StringVar(&config.dnsHosts, "dns-hosts", "", "a comma separated list of hostnames whose a records are the machines")
+	flag.StringVar(&config.dnsPattern, "dns-pattern", "", "a regex with named groups used to derive metadata from the hostnames, i.e. ^(?P<role>[a-z]+)-")
+	flag.StringVar(&config.dnsMetadata, "dns-metadata", "", "static metadata (tag=value,...) added to the machines discovered in dns")
+	flag.StringVar(&config.dnsServer, "dns-server", "", "the address of a dns server to use rather than the system resolver")
+	flag.DurationVar(&config.dnsTimeout, "dns-timeout", defaultDNSTimeout, "the timeout for dns lookups")
+	flag.DurationVar(&config.dnsInterval, "dns-interval", defaultDNSCheck, "the interval to check dns for changes to the machines")
+	flag.StringVar(&config.consulAddress, "consul", "http://127.0.0.1:8500", "the consul http api endpoint")
+	flag.StringVar(&config.consulService, "consul-service", "", "only include the consul nodes providing this service")
+	flag.StringVar(&config.consulTag, "consul-tag", "", "only include the consul nodes whose service has this tag")
+	flag.StringVar(&config.consulDatacenter, "consul-datacenter", "", "the consul datacenter to list the nodes from, defaults to the agent's")
+	flag.StringVar(&config.consulToken, "consul-token", "", "an acl token used when querying the consul catalog")
+	flag.DurationVar(&config.consulWait, "consul-wait", defaultConsulWait, "the maximum time to wait on a consul blocking query")
+	flag.DurationVar(&config.consulTimeout, "consul-timeout", defaultConsulTimeout, "the timeout for listing the machines in the consul catalog")
+	flag.StringVar(&config.fleetSocket, "fleet", "unix://var/run/fleet.sock", "the fleet endpoint, either a unix socket or a http / https url")
+	flag.StringVar(&config.fleetCAFile, "fleet-ca-file", "", "a certificate authority used to verify the fleet api")
+	flag.StringVar(&config.fleetCertFile, "fleet-cert-file", "", "a client certificate used to authenticate to the fleet api")
+	flag.StringVar(&config.fleetKeyFile, "fleet-key-file", "", "the private key for the fleet client certificate")
+	flag.DurationVar(&config.fleetTimeout, "fleet-timeout", defaultFleetTimeout, "the timeout for requests to the fleet api")
+	flag.StringVar(&config.fleetTunnel, "fleet-tunnel", "", "a host[:port] to tunnel to the fleet api via ssh")
+	flag.StringVar(&config.fleetSSHUser, "fleet-ssh-user", "core", "the user used to establish the ssh tunnel")
+	flag.StringVar(&config.fleetSSHKey, "fleet-ssh-key", "", "a private key used for the ssh tunnel, defaults to the ssh agent")
+	flag.StringVar(&config.fleetKnownHosts, "fleet-known-hosts", defaultKnownHosts(), "the known hosts file used to verify the host key of the ssh tunnel")
+	flag.BoolVar(&config.fleetStrictHostKeys, "fleet-strict-host-key-checking", true, "refuse to tunnel to a host whose key is not in the known hosts file")
 	flag.StringVar(&config.fleetInterface, "interface", "", "you can either specify the interface and we'll grab the ip address or the ip below")
 	flag.StringVar(&config.fleetIPAddress, "address", "", "the public ip address using by fleet, only used on standalone mode")
 	flag.StringVar(&config.kubeVersion, "api-version", "v1", "the kubernetes api version")
@@ -95,6 +320,7 @@ func init() {
 	flag.BoolVar(&config.kubeNodeRepear, "node-reaper", false, "enable the removal of dead nodes from the kubernetes")
 	flag.DurationVar(&config.kubeNodeDowntime, "reap-interval", defaultReaperInterval, "the amount of time a node can be down before removal")
 	flag.DurationVar(&config.timeInterval, "interval", defaultSyncInterval, "the amount of time in seconds to check if nodes registered")
+	flag.DurationVar(&config.shutdownTimeout, "shutdown-timeout", defaultShutdown, "the maximum time to wait on shutdown before forcing an exit")
 	flag.IntVar(&config.kubeHealthPort, "port", 10255, "the port the kubelet is running the health endpoint on")
 	flag.BoolVar(&config.showVersion, "version", false, "display the node register version")
 }
@@ -127,36 +353,162 @@ func parseConfig() error {
 		os.Exit(0)
 	}
 
+	// step: parse the subcommand, its arguments and any options following it, i.e. reap -dry-run
+	if flag.NArg() > 0 {
+		config.command = flag.Arg(0)
+		for args := flag.Args()[1:]; len(args) > 0; args = flag.Args()[1:] {
+			flag.CommandLine.Parse(args)
+			if flag.NArg() <= 0 {
+				break
+			}
+			config.commandArgs = append(config.commandArgs, flag.Arg(0))
+		}
+	}
+	// check: ensure the subcommand is valid, a plan is always a dry run
+	if config.command != "" {
+		expected, found := commands[config.command]
+		if !found {
+			return fmt.Errorf("unknown command: %s, should be run, once, plan, machines, nodes, register, deregister or reap", config.command)
+		}
+		if len(config.commandArgs) != expected {
+			return fmt.Errorf("the command: %s expects %d arguments", config.command, expected)
+		}
+	}
+	if config.command == "plan" {
+		config.dryRun = true
+	}
+	switch config.outputFormat {
+	case "table", "json":
+	default:
+		return fmt.Errorf("invalid output format: %s, should be table or json", config.outputFormat)
+	}
+
 	// check: ensure the interval is great than 10 seconds
 	if config.timeInterval < 10 {
 		return fmt.Errorf("the sync interval should be greater then 10 seconds")
 	}
+	// check: ensure the shutdown timeout is positive
+	if config.shutdownTimeout <= 0 {
+		return fmt.Errorf("the shutdown timeout should be greater than zero")
+	}
 	// check: ensure the metadata is valid
-	if matched := metadataRegex.MatchString(config.metadata); !matched {
-		return fmt.Errorf("invalid metadata, should be tag=value format")
+	if _, err := parseSelector(config.metadata); err != nil {
+		return err
+	}
+
+	// check: ensure the tls and ssh files exist
+	for _, filename := range []string{config.fleetCAFile, config.fleetCertFile, config.fleetKeyFile, config.fleetSSHKey,
+		config.etcdCAFile, config.etcdCertFile, config.etcdKeyFile} {
+		if filename == "" {
+			continue
+		}
+		if _, err := os.Stat(filename); os.IsNotExist(err) {
+			return fmt.Errorf("the file: %s does not exist", filename)
+		}
+	}
+	if (config.fleetCertFile == "") != (config.fleetKeyFile == "") {
+		return fmt.Errorf("you must specify both the fleet certificate and private key")
+	}
+	if (config.etcdCertFile == "") != (config.etcdKeyFile == "") {
+		return fmt.Errorf("you must specify both the etcd certificate and private key")
+	}
+	// check: ensure the machine sources are valid
+	config.machineSources = nil
+	for _, name := range strings.Split(config.machineSource, ",") {
+		name = strings.TrimSpace(name)
+		switch name {
+		case "fleet", "etcd", "file", "dns", "consul":
+		default:
+			return fmt.Errorf("invalid machine source: %s, should be fleet, etcd, file, dns or consul", name)
+		}
+		if hasSource(name) {
+			return fmt.Errorf("the machine source: %s is specified more than once", name)
+		}
+		config.machineSources = append(config.machineSources, name)
+	}
+	if hasSource("etcd") && config.etcdEndpoints == "" {
+		return fmt.Errorf("you must specify the etcd endpoints when using the etcd source")
+	}
+	if hasSource("dns") && config.dnsSRV == "" && config.dnsHosts == "" {
+		return fmt.Errorf("you must specify either a dns srv record or hostnames when using the dns source")
+	}
+	if config.consulTag != "" && config.consulService == "" {
+		return fmt.Errorf("you must specify the consul service when filtering by a tag")
+	}
+	if hasSource("file") {
+		if config.inventoryFile == "" {
+			return fmt.Errorf("you must specify the inventory file when using the file source")
+		}
+		if _, err := os.Stat(config.inventoryFile); os.IsNotExist(err) {
+			return fmt.Errorf("the inventory file: %s does not exist", config.inventoryFile)
+		}
+	}
+
+	// check: ensure the dns options are valid
+	switch config.dnsSelect {
+	case "first", "shortest", "longest", "sorted":
+	default:
+		return fmt.Errorf("invalid dns select: %s, should be first, shortest, longest or sorted", config.dnsSelect)
+	}
+	switch config.dnsFallback {
+	case "ip", "skip", "fail":
+	default:
+		return fmt.Errorf("invalid dns fallback: %s, should be ip, skip or fail", config.dnsFallback)
 	}
-	// check: ensure the token file exists
-	if config.kubeTokenFile != "" {
-		if _, err := os.Stat(config.kubeTokenFile); os.IsNotExist(err) {
-			return fmt.Errorf("the token file: %s does not exist", config.kubeTokenFile)
+
+	// check: ensure the node name template is valid
+	config.nameTemplate = nil
+	if config.nodeNameTemplate != "" {
+		if config.nameTemplate, err = parseNodeNameTemplate(config.nodeNameTemplate); err != nil {
+			return err
 		}
 	}
-	// check: ensure the cert exists
-	if config.kubeCert != "" {
-		if _, err := os.Stat(config.kubeCert); os.IsNotExist(err) {
-			return fmt.Errorf("the kube cert file: %s does not exist", config.kubeCert)
+
+	// check: ensure the node address types are valid
+	config.nodeAddressTypes = make(map[api.NodeAddressType]bool, 0)
+	if config.nodeAddresses != "" {
+		for _, name := range strings.Split(config.nodeAddresses, ",") {
+			addressType := api.NodeAddressType(strings.TrimSpace(name))
+			switch addressType {
+			case api.NodeInternalIP, api.NodeExternalIP, api.NodeHostName:
+				config.nodeAddressTypes[addressType] = true
+			default:
+				return fmt.Errorf("invalid node address type: %s, should be InternalIP, ExternalIP or Hostname", name)
+			}
 		}
 	}
 
-	// check: ensure the url is valid
-	if _, err := url.Parse(config.kubeAPI); err != nil {
-		return fmt.Errorf("invalid url for kubernete api, error: %s", err)
+	// check: ensure the capacity buckets are valid
+	if config.capacityBuckets, err = parseCapacityBuckets(config.sizeBuckets); err != nil {
+		return err
+	}
+	if len(config.capacityBuckets) > 0 && !config.kubeletSpec {
+		return fmt.Errorf("the size buckets require the kubelet spec to be enabled")
+	}
+	if len(config.capacityBuckets) > 0 && !util.IsQualifiedName(config.sizeLabel) {
+		return fmt.Errorf("invalid size label: %s, should be a qualified label name", config.sizeLabel)
+	}
+
+	// check: ensure we keep at least one sync cycle
+	if config.cycleHistory <= 0 {
+		return fmt.Errorf("the cycle history should be greater than zero")
 	}
 
-	// step: extract the tags
-	matches := metadataRegex.FindAllStringSubmatch(config.metadata, 1)[0]
-	config.tagName = string(matches[1])
-	config.tagValue = string(matches[2])
+	// step: build the kubernetes clusters we are registering into
+	if config.clustersFile != "" {
+		if _, err := os.Stat(config.clustersFile); os.IsNotExist(err) {
+			return fmt.Errorf("the clusters file: %s does not exist", config.clustersFile)
+		}
+		if clusters, err = loadClusters(config.clustersFile); err != nil {
+			return err
+		}
+	} else {
+		cluster, err := defaultCluster()
+		if err != nil {
+			return err
+		}
+		clusters = []*ClusterTarget{cluster}
+	}
 
 	// step: if we are running in standalone more, we need the ip address
 	if config.standalone {
@@ -175,3 +527,29 @@ func parseConfig() error {
 
 	return nil
 }
+
+// defaultInstanceID returns the hostname of the machine we are running on
+func defaultInstanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+
+	return hostname
+}
+
+// defaultKnownHosts returns the known hosts file of the user we are running as
+func defaultKnownHosts() string {
+	return filepath.Join(os.Getenv("HOME"), ".ssh", "known_hosts")
+}
+
+// hasSource checks if the machine source is enabled
+func hasSource(name string) bool {
+	for _, x := range config.machineSources {
+		if x == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,234 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// consulNode ... the structure of a node or service entry in the consul catalog
+type consulNode struct {
+	// the unique id of the node
+	ID string `json:"ID"`
+	// the name of the node
+	Node string `json:"Node"`
+	// the address of the node
+	Address string `json:"Address"`
+	// any additional addresses of the node, i.e. lan, wan
+	TaggedAddresses map[string]string `json:"TaggedAddresses"`
+	// the node metadata
+	Meta map[string]string `json:"Meta"`
+	// the node metadata, when listing a service
+	NodeMeta map[string]string `json:"NodeMeta"`
+	// the service tags, when listing a service
+	ServiceTags []string `json:"ServiceTags"`
+}
+
+// NewConsulInterface creates a new interface to read the machines from the consul catalog
+func NewConsulInterface() (*ConsulInterface, error) {
+	glog.V(3).Infof("Creating a client to consul, endpoint: %s, service: '%s', tag: '%s'",
+		config.consulAddress, config.consulService, config.consulTag)
+
+	location, err := url.Parse(config.consulAddress)
+	if err != nil {
+		return nil, fmt.Errorf("invalid consul address: %s, error: %s", config.consulAddress, err)
+	}
+
+	return &ConsulInterface{
+		location: location,
+		wait:     config.consulWait,
+		backoff:  time.Duration(5) * time.Second,
+		httpClient: &http.Client{
+			Timeout: config.consulTimeout,
+		},
+		// step: the timeout must exceed the blocking query wait plus the jitter consul adds (wait / 16)
+		watchClient: &http.Client{
+			Timeout: config.consulWait + config.consulWait/16 + config.consulTimeout,
+		},
+	}, nil
+}
+
+// GetMachines return a list of machines from the consul catalog
+func (r ConsulInterface) GetMachines(ctx context.Context) ([]*Machine, error) {
+	glog.V(5).Infof("Retrieving a list of the machines from the consul catalog")
+
+	nodes, _, err := r.catalog(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// step: constructing a list of machine
+	var list []*Machine
+	found := make(map[string]bool, 0)
+	for _, x := range nodes {
+		if found[x.Address] {
+			continue
+		}
+		found[x.Address] = true
+		glog.V(6).Infof("Adding the machine: %s (%s) to the list of consul nodes", x.Address, x.Node)
+		addresses := []string{x.Address}
+		for _, address := range x.TaggedAddresses {
+			if address != x.Address {
+				addresses = append(addresses, address)
+			}
+		}
+		list = append(list, &Machine{
+			Name:      x.Address,
+			ID:        x.ID,
+			Addresses: addresses,
+			Metadata:  consulMetadata(x),
+		})
+	}
+	glog.V(4).Infof("Found %d machine in the consul catalog", len(list))
+	return list, nil
+}
+
+// Watch uses blocking queries to watch the consul catalog for changes
+func (r ConsulInterface) Watch(stop chan struct{}) chan struct{} {
+	updates := make(chan struct{}, 1)
+
+	// step: cancel any blocking query on stop
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	go func() {
+		var index uint64
+		var delay time.Duration
+		for {
+			// step: rate limit the queries in case consul returns without blocking
+			select {
+			case <-stop:
+				return
+			case <-time.After(delay):
+			}
+			delay = time.Second
+
+			_, next, err := r.catalog(ctx, index)
+			if err != nil {
+				glog.Errorf("Failed to watch the consul catalog, error: %s", err)
+				// step: we don't want to hammer consul, wait a little before retrying
+				delay = r.backoff
+				continue
+			}
+			// step: without an index the query cannot block, so we must back off
+			if next == 0 {
+				glog.V(4).Infof("The consul catalog did not return an index, retrying in %s", r.backoff)
+				delay = r.backoff
+			}
+			// step: has the catalog changed? - the first query simply sets the index
+			if index != 0 && next != index {
+				glog.V(3).Infof("The consul catalog has changed, index: %d", next)
+				select {
+				case updates <- struct{}{}:
+				default:
+				}
+			}
+			// step: the index must be reset if it goes backwards
+			if next < index {
+				next = 0
+			}
+			index = next
+		}
+	}()
+
+	return updates
+}
+
+// catalog queries the consul catalog, blocking until the index has changed if one is given
+func (r ConsulInterface) catalog(ctx context.Context, index uint64) ([]consulNode, uint64, error) {
+	location := *r.location
+	params := url.Values{}
+	location.Path = "/v1/catalog/nodes"
+	if config.consulService != "" {
+		location.Path = "/v1/catalog/service/" + url.QueryEscape(config.consulService)
+		if config.consulTag != "" {
+			params.Set("tag", config.consulTag)
+		}
+	}
+	if config.consulDatacenter != "" {
+		params.Set("dc", config.consulDatacenter)
+	}
+	if index > 0 {
+		params.Set("index", strconv.FormatUint(index, 10))
+		params.Set("wait", fmt.Sprintf("%ds", int(r.wait.Seconds())))
+	}
+	location.RawQuery = params.Encode()
+
+	request, err := http.NewRequest("GET", location.String(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	request = request.WithContext(ctx)
+	if config.consulToken != "" {
+		request.Header.Set("X-Consul-Token", config.consulToken)
+	}
+
+	// step: the blocking queries require the client with the longer timeout
+	client := r.httpClient
+	if index > 0 {
+		client = r.watchClient
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query the consul catalog, error: %s", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("failed to query the consul catalog, status: %s", response.Status)
+	}
+
+	var nodes []consulNode
+	if err := json.NewDecoder(response.Body).Decode(&nodes); err != nil {
+		return nil, 0, fmt.Errorf("unable to decode the consul catalog, error: %s", err)
+	}
+	next, _ := strconv.ParseUint(response.Header.Get("X-Consul-Index"), 10, 64)
+
+	return nodes, next, nil
+}
+
+// consulMetadata maps the node meta and service tags into the machine metadata, tags in the
+// form key=value are split, any other tag is set to true
+func consulMetadata(node consulNode) map[string]string {
+	metadata := make(map[string]string, 0)
+	for name, value := range node.Meta {
+		metadata[name] = value
+	}
+	for name, value := range node.NodeMeta {
+		metadata[name] = value
+	}
+	for _, tag := range node.ServiceTags {
+		if items := strings.SplitN(tag, "=", 2); len(items) == 2 {
+			metadata[items[0]] = items[1]
+			continue
+		}
+		metadata[tag] = "true"
+	}
+
+	return metadata
+}
@@ -0,0 +1,195 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeConsul ... a consul agent serving a fixed catalog, recording the queries made
+type fakeConsul struct {
+	sync.Mutex
+	// the body returned for the catalog
+	body string
+	// the index returned, or none if zero
+	index int
+	// the queries received
+	queries []*http.Request
+}
+
+func (r *fakeConsul) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.Lock()
+	r.queries = append(r.queries, req)
+	index := r.index
+	r.Unlock()
+	if index > 0 {
+		w.Header().Set("X-Consul-Index", fmt.Sprintf("%d", index))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, r.body)
+}
+
+func (r *fakeConsul) Queries() []*http.Request {
+	r.Lock()
+	defer r.Unlock()
+	return append([]*http.Request{}, r.queries...)
+}
+
+func newTestConsul(t *testing.T, fake *fakeConsul) (*httptest.Server, *ConsulInterface) {
+	server := httptest.NewServer(fake)
+	location, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("unable to parse the server url, error: %s", err)
+	}
+
+	return server, &ConsulInterface{
+		location:    location,
+		wait:        time.Second,
+		backoff:     time.Duration(200) * time.Millisecond,
+		httpClient:  &http.Client{Timeout: time.Second},
+		watchClient: &http.Client{Timeout: time.Duration(5) * time.Second},
+	}
+}
+
+func TestConsulGetMachines(t *testing.T) {
+	fake := &fakeConsul{
+		index: 10,
+		body: `[
+			{"ID": "n1", "Node": "node1", "Address": "10.0.0.1", "TaggedAddresses": {"lan": "10.0.0.1", "wan": "1.1.1.1"},
+			 "Meta": {"role": "worker"}},
+			{"ID": "n2", "Node": "node2", "Address": "10.0.0.2", "NodeMeta": {"zone": "eu1"},
+			 "ServiceTags": ["kubelet", "zone=eu2"]},
+			{"ID": "n1", "Node": "node1", "Address": "10.0.0.1"}
+		]`,
+	}
+	server, service := newTestConsul(t, fake)
+	defer server.Close()
+
+	machines, err := service.GetMachines(context.Background())
+	if err != nil {
+		t.Fatalf("unable to retrieve the machines, error: %s", err)
+	}
+	expected := []*Machine{
+		{Name: "10.0.0.1", ID: "n1", Addresses: []string{"10.0.0.1", "1.1.1.1"}, Metadata: map[string]string{"role": "worker"}},
+		{Name: "10.0.0.2", ID: "n2", Addresses: []string{"10.0.0.2"}, Metadata: map[string]string{"zone": "eu2", "kubelet": "true"}},
+	}
+	if !reflect.DeepEqual(machines, expected) {
+		t.Errorf("unexpected machines, expected: %v, got: %v", expected, machines)
+	}
+
+	queries := fake.Queries()
+	if len(queries) != 1 || queries[0].URL.Path != "/v1/catalog/nodes" || queries[0].URL.Query().Get("index") != "" {
+		t.Errorf("expected a single non-blocking query of the nodes, got: %v", queries)
+	}
+}
+
+func TestConsulGetMachinesService(t *testing.T) {
+	config.consulService, config.consulTag, config.consulDatacenter, config.consulToken = "kubelet", "prod", "dc2", "secret"
+	defer func() {
+		config.consulService, config.consulTag, config.consulDatacenter, config.consulToken = "", "", "", ""
+	}()
+	fake := &fakeConsul{body: `[]`}
+	server, service := newTestConsul(t, fake)
+	defer server.Close()
+
+	if _, err := service.GetMachines(context.Background()); err != nil {
+		t.Fatalf("unable to retrieve the machines, error: %s", err)
+	}
+	queries := fake.Queries()
+	if len(queries) != 1 {
+		t.Fatalf("expected a single query, got: %d", len(queries))
+	}
+	query := queries[0]
+	if query.URL.Path != "/v1/catalog/service/kubelet" {
+		t.Errorf("unexpected path: %s", query.URL.Path)
+	}
+	if query.URL.Query().Get("tag") != "prod" || query.URL.Query().Get("dc") != "dc2" {
+		t.Errorf("unexpected query: %s", query.URL.RawQuery)
+	}
+	if query.Header.Get("X-Consul-Token") != "secret" {
+		t.Errorf("expected the acl token to be sent")
+	}
+}
+
+func TestConsulGetMachinesFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "no cluster leader", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	location, _ := url.Parse(server.URL)
+	service := &ConsulInterface{location: location, httpClient: &http.Client{Timeout: time.Second}}
+
+	if _, err := service.GetMachines(context.Background()); err == nil {
+		t.Errorf("expected an error when consul fails")
+	}
+}
+
+func TestConsulWatchChanges(t *testing.T) {
+	fake := &fakeConsul{index: 1, body: `[]`}
+	server, service := newTestConsul(t, fake)
+	defer server.Close()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	updates := service.Watch(stop)
+
+	// step: wait for the first query to set the index, then change the catalog
+	for len(fake.Queries()) == 0 {
+		time.Sleep(time.Duration(10) * time.Millisecond)
+	}
+	fake.Lock()
+	fake.index = 2
+	fake.Unlock()
+
+	select {
+	case <-updates:
+	case <-time.After(time.Duration(5) * time.Second):
+		t.Fatalf("expected a change to be signalled")
+	}
+	if index := fake.Queries()[1].URL.Query().Get("index"); index != "1" {
+		t.Errorf("expected a blocking query on index 1, got: '%s'", index)
+	}
+}
+
+func TestConsulWatchWithoutIndex(t *testing.T) {
+	fake := &fakeConsul{body: `[]`}
+	server, service := newTestConsul(t, fake)
+	defer server.Close()
+
+	stop := make(chan struct{})
+	service.Watch(stop)
+	time.Sleep(time.Duration(500) * time.Millisecond)
+	close(stop)
+
+	// step: with a backoff of 200ms we expect a handful of queries, not a busy loop
+	if count := len(fake.Queries()); count < 1 || count > 4 {
+		t.Errorf("expected the watch to back off without an index, queries: %d", count)
+	}
+	for _, query := range fake.Queries() {
+		if query.URL.Query().Get("index") != "" {
+			t.Errorf("expected no blocking queries without an index, got: %s", query.URL.RawQuery)
+		}
+	}
+}
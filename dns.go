@@ -0,0 +1,223 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// NewDNSInterface creates a new interface to discover the machines from dns
+func NewDNSInterface() (*DNSInterface, error) {
+	glog.V(3).Infof("Creating a dns discovery source, srv: '%s', hosts: '%s'", config.dnsSRV, config.dnsHosts)
+	service := &DNSInterface{
+		srv:      config.dnsSRV,
+		interval: config.dnsInterval,
+		resolver: newResolver(config.dnsServer, config.dnsTimeout),
+		timeout:  config.dnsTimeout,
+	}
+	if config.dnsHosts != "" {
+		service.hosts = strings.Split(config.dnsHosts, ",")
+	}
+
+	// step: parse the metadata pattern
+	if config.dnsPattern != "" {
+		pattern, err := regexp.Compile(config.dnsPattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dns metadata pattern: %s, error: %s", config.dnsPattern, err)
+		}
+		service.pattern = pattern
+	}
+	// step: parse the static metadata
+	service.metadata = make(map[string]string, 0)
+	if config.dnsMetadata != "" {
+		metadata, err := parseSelector(config.dnsMetadata)
+		if err != nil {
+			return nil, err
+		}
+		service.metadata = metadata
+	}
+
+	return service, nil
+}
+
+// newResolver creates a dns resolver, optionally using a specific dns server
+func newResolver(server string, timeout time.Duration) *net.Resolver {
+	if server == "" {
+		return net.DefaultResolver
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			dialer := &net.Dialer{Timeout: timeout}
+			return dialer.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// GetMachines return a list of machines discovered from dns, a host which fails to resolve is skipped and
+// reported in a partial error rather than failing all the machines
+func (r DNSInterface) GetMachines(ctx context.Context) ([]*Machine, error) {
+	glog.V(5).Infof("Retrieving a list of the machines from dns")
+
+	// step: get the list of hostnames
+	hostnames := r.hosts
+	if r.srv != "" {
+		lookup, cancel := context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+		_, records, err := r.resolver.LookupSRV(lookup, "", "", r.srv)
+		if err != nil {
+			return nil, fmt.Errorf("failed to lookup the srv record: %s, error: %s", r.srv, err)
+		}
+		for _, record := range records {
+			hostnames = append(hostnames, record.Target)
+		}
+	}
+
+	// step: resolve the hostnames into machines
+	var list []*Machine
+	var failures []error
+	found := make(map[string]bool, 0)
+	for _, hostname := range hostnames {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("the dns lookups were interrupted, error: %s", ctx.Err())
+		}
+		addresses, err := r.lookupHost(ctx, hostname)
+		if err != nil {
+			glog.Errorf("Failed to resolve the host: %s, skipping it, error: %s", hostname, err)
+			failures = append(failures, fmt.Errorf("failed to resolve the host: %s, error: %s", hostname, err))
+			continue
+		}
+		for _, address := range addresses {
+			if found[address] {
+				continue
+			}
+			found[address] = true
+			glog.V(6).Infof("Adding the machine: %s (%s) to the list of dns nodes", address, hostname)
+			list = append(list, &Machine{
+				Name:      address,
+				Addresses: []string{address},
+				Metadata:  r.hostMetadata(hostname),
+			})
+		}
+	}
+	glog.V(4).Infof("Found %d machine in dns", len(list))
+	switch {
+	case len(failures) > 0 && len(failures) == len(hostnames):
+		return nil, fmt.Errorf("unable to resolve any of the hosts, error: %s", &partialError{errors: failures})
+	case len(failures) > 0:
+		return list, &partialError{errors: failures}
+	}
+
+	return list, nil
+}
+
+// Watch polls dns for any changes to the machines
+func (r DNSInterface) Watch(stop chan struct{}) chan struct{} {
+	updates := make(chan struct{}, 1)
+
+	go func() {
+		last := r.snapshot()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(r.interval):
+			}
+			// step: have the addresses changed since we last looked?
+			if current := r.snapshot(); current != last {
+				glog.V(3).Infof("The machines discovered in dns have changed")
+				last = current
+				select {
+				case updates <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	return updates
+}
+
+// snapshot returns a sorted list of the addresses currently in dns
+func (r DNSInterface) snapshot() string {
+	machines, err := r.GetMachines(context.Background())
+	if _, found := isPartialError(err); err != nil && !found {
+		glog.Errorf("Unable to discover the machines from dns, error: %s", err)
+		return ""
+	}
+	var addresses []string
+	for _, machine := range machines {
+		addresses = append(addresses, machine.Name)
+	}
+	sort.Strings(addresses)
+
+	return strings.Join(addresses, ",")
+}
+
+// lookupHost resolves the hostname to a list of ipv4 addresses
+func (r DNSInterface) lookupHost(ctx context.Context, hostname string) ([]string, error) {
+	lookup, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	addresses, err := r.resolver.LookupIPAddr(lookup, hostname)
+	if err != nil {
+		return nil, err
+	}
+	var list []string
+	for _, address := range addresses {
+		if address.IP.To4() != nil {
+			list = append(list, address.IP.String())
+		}
+	}
+
+	return list, nil
+}
+
+// hostMetadata derives the metadata for a host from the static metadata and the named groups in the pattern
+func (r DNSInterface) hostMetadata(hostname string) map[string]string {
+	metadata := make(map[string]string, 0)
+	for name, value := range r.metadata {
+		metadata[name] = value
+	}
+	if r.pattern == nil {
+		return metadata
+	}
+
+	matches := r.pattern.FindStringSubmatch(strings.TrimSuffix(hostname, "."))
+	if matches == nil {
+		glog.V(5).Infof("The host: %s does not match the dns metadata pattern", hostname)
+		return metadata
+	}
+	for i, name := range r.pattern.SubexpNames() {
+		if name != "" && matches[i] != "" {
+			metadata[name] = matches[i]
+		}
+	}
+
+	return metadata
+}
@@ -0,0 +1,272 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	dnsTypeA   = 1
+	dnsTypePTR = 12
+	dnsTypeSRV = 33
+)
+
+// testDNSServer ... a minimal dns server answering a, ptr and srv queries over udp
+type testDNSServer struct {
+	conn net.PacketConn
+	// the a records keyed by the fully qualified name
+	hosts map[string][]string
+	// the srv targets keyed by the fully qualified name
+	srv map[string][]string
+	// the ptr records keyed by the reverse name, i.e. 1.0.0.10.in-addr.arpa.
+	ptr map[string][]string
+	// the number of queries answered
+	queries int32
+}
+
+func newTestDNSServer(t *testing.T, hosts, srv map[string][]string) *testDNSServer {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen for dns queries, error: %s", err)
+	}
+	server := &testDNSServer{conn: conn, hosts: hosts, srv: srv}
+	go server.serve()
+
+	return server
+}
+
+func (r *testDNSServer) Close() {
+	r.conn.Close()
+}
+
+func (r *testDNSServer) serve() {
+	buffer := make([]byte, 512)
+	for {
+		n, remote, err := r.conn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		if response := r.answer(buffer[:n]); response != nil {
+			r.conn.WriteTo(response, remote)
+		}
+	}
+}
+
+// answer builds the response to the query, ignoring anything but the question
+func (r *testDNSServer) answer(query []byte) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+	atomic.AddInt32(&r.queries, 1)
+	// step: read the question name
+	offset := 12
+	var labels []string
+	for offset < len(query) && query[offset] != 0 {
+		size := int(query[offset])
+		if offset+1+size > len(query) {
+			return nil
+		}
+		labels = append(labels, string(query[offset+1:offset+1+size]))
+		offset += 1 + size
+	}
+	if offset+5 > len(query) {
+		return nil
+	}
+	name := strings.ToLower(strings.Join(labels, ".")) + "."
+	qtype := binary.BigEndian.Uint16(query[offset+1:])
+	question := query[12 : offset+5]
+
+	var answers [][]byte
+	_, knownHost := r.hosts[name]
+	_, knownSRV := r.srv[name]
+	_, knownPTR := r.ptr[name]
+	switch qtype {
+	case dnsTypePTR:
+		for _, hostname := range r.ptr[name] {
+			answers = append(answers, dnsRecord(dnsTypePTR, dnsName(hostname)))
+		}
+	case dnsTypeA:
+		for _, address := range r.hosts[name] {
+			answers = append(answers, dnsRecord(dnsTypeA, net.ParseIP(address).To4()))
+		}
+	case dnsTypeSRV:
+		for i, target := range r.srv[name] {
+			data := make([]byte, 6)
+			binary.BigEndian.PutUint16(data[0:], uint16(i+1))
+			binary.BigEndian.PutUint16(data[2:], 0)
+			binary.BigEndian.PutUint16(data[4:], 10250)
+			answers = append(answers, dnsRecord(dnsTypeSRV, append(data, dnsName(target)...)))
+		}
+	}
+
+	header := make([]byte, 12)
+	copy(header, query[:2])
+	flags := uint16(0x8180)
+	if !knownHost && !knownSRV && !knownPTR {
+		flags |= 3
+	}
+	binary.BigEndian.PutUint16(header[2:], flags)
+	binary.BigEndian.PutUint16(header[4:], 1)
+	binary.BigEndian.PutUint16(header[6:], uint16(len(answers)))
+	response := append(header, question...)
+	for _, answer := range answers {
+		response = append(response, answer...)
+	}
+
+	return response
+}
+
+// dnsRecord encodes a resource record for the name in the question
+func dnsRecord(rtype uint16, data []byte) []byte {
+	record := make([]byte, 12)
+	binary.BigEndian.PutUint16(record[0:], 0xc00c)
+	binary.BigEndian.PutUint16(record[2:], rtype)
+	binary.BigEndian.PutUint16(record[4:], 1)
+	binary.BigEndian.PutUint32(record[6:], 60)
+	binary.BigEndian.PutUint16(record[10:], uint16(len(data)))
+
+	return append(record, data...)
+}
+
+// dnsName encodes the name as a sequence of labels
+func dnsName(name string) []byte {
+	var encoded []byte
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		encoded = append(encoded, byte(len(label)))
+		encoded = append(encoded, label...)
+	}
+
+	return append(encoded, 0)
+}
+
+func TestDNSHostMetadata(t *testing.T) {
+	service := DNSInterface{
+		pattern:  regexp.MustCompile(`^(?P<role>[a-z]+)-(?P<zone>[a-z0-9]+)(-(?P<spare>x))?\.`),
+		metadata: map[string]string{"env": "prod", "zone": "default"},
+	}
+	cases := []struct {
+		Hostname string
+		Expected map[string]string
+	}{
+		{
+			Hostname: "worker-eu1.example.com.",
+			Expected: map[string]string{"env": "prod", "role": "worker", "zone": "eu1"},
+		},
+		{
+			Hostname: "master-eu2-x.example.com",
+			Expected: map[string]string{"env": "prod", "role": "master", "zone": "eu2", "spare": "x"},
+		},
+		{
+			Hostname: "10-0-0-1.example.com.",
+			Expected: map[string]string{"env": "prod", "zone": "default"},
+		},
+	}
+	for i, c := range cases {
+		if metadata := service.hostMetadata(c.Hostname); !reflect.DeepEqual(metadata, c.Expected) {
+			t.Errorf("case %d, host: %s, expected: %v, got: %v", i, c.Hostname, c.Expected, metadata)
+		}
+	}
+
+	// step: without a pattern we only get the static metadata
+	service.pattern = nil
+	if metadata := service.hostMetadata("worker-eu1.example.com."); !reflect.DeepEqual(metadata, service.metadata) {
+		t.Errorf("expected only the static metadata, got: %v", metadata)
+	}
+}
+
+func TestDNSGetMachines(t *testing.T) {
+	server := newTestDNSServer(t,
+		map[string][]string{
+			"worker-eu1.example.com.": {"10.0.0.1", "10.0.0.2"},
+			"worker-eu2.example.com.": {"10.0.0.2"},
+			"master-eu1.example.com.": {"10.0.0.10"},
+		},
+		map[string][]string{
+			"_kubelet._tcp.example.com.": {"worker-eu1.example.com.", "worker-eu2.example.com."},
+		},
+	)
+	defer server.Close()
+
+	service := DNSInterface{
+		srv:      "_kubelet._tcp.example.com.",
+		hosts:    []string{"master-eu1.example.com."},
+		pattern:  regexp.MustCompile(`^(?P<role>[a-z]+)-(?P<zone>[a-z0-9]+)\.`),
+		metadata: map[string]string{},
+		resolver: newResolver(server.conn.LocalAddr().String(), time.Second),
+		timeout:  time.Duration(2) * time.Second,
+	}
+	machines, err := service.GetMachines(context.Background())
+	if err != nil {
+		t.Fatalf("unable to retrieve the machines, error: %s", err)
+	}
+
+	expected := map[string]map[string]string{
+		"10.0.0.10": {"role": "master", "zone": "eu1"},
+		"10.0.0.1":  {"role": "worker", "zone": "eu1"},
+		"10.0.0.2":  {"role": "worker", "zone": "eu1"},
+	}
+	if len(machines) != len(expected) {
+		t.Fatalf("expected %d machines, got: %d", len(expected), len(machines))
+	}
+	for _, machine := range machines {
+		metadata, found := expected[machine.Name]
+		if !found {
+			t.Errorf("unexpected machine: %s", machine.Name)
+			continue
+		}
+		if !reflect.DeepEqual(machine.Metadata, metadata) {
+			t.Errorf("machine: %s, expected metadata: %v, got: %v", machine.Name, metadata, machine.Metadata)
+		}
+		if !reflect.DeepEqual(machine.Addresses, []string{machine.Name}) {
+			t.Errorf("machine: %s, unexpected addresses: %v", machine.Name, machine.Addresses)
+		}
+	}
+
+	// step: a host which does not resolve is skipped and reported
+	service.hosts = append(service.hosts, "missing.example.com.")
+	machines, err = service.GetMachines(context.Background())
+	if partial, found := isPartialError(err); !found || len(partial.errors) != 1 {
+		t.Errorf("expected a partial error for the host which does not resolve, got: %v", err)
+	}
+	if len(machines) != len(expected) {
+		t.Errorf("expected the %d machines which resolved, got: %d", len(expected), len(machines))
+	}
+	// step: when none of the hosts resolve the lookup fails
+	service.srv = ""
+	service.hosts = []string{"missing.example.com."}
+	if _, err := service.GetMachines(context.Background()); err == nil {
+		t.Errorf("expected an error when none of the hosts resolve")
+	} else if _, found := isPartialError(err); found {
+		t.Errorf("expected a complete failure when none of the hosts resolve, got: %v", err)
+	}
+	// step: as does a cancelled context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	service.hosts = []string{"master-eu1.example.com."}
+	if _, err := service.GetMachines(ctx); err == nil {
+		t.Errorf("expected an error when the context is cancelled")
+	}
+}
@@ -17,30 +17,294 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sync"
+	"time"
 
-	fleet "github.com/coreos/fleet/client"
+	etcd "github.com/coreos/etcd/client"
+	"github.com/coreos/fleet/registry"
+	cadvisor "github.com/google/cadvisor/info/v1"
 	kube "k8s.io/kubernetes/pkg/client"
 )
 
+// MachineSource ... is the interface to a provider of machines, i.e. fleet, etcd
+type MachineSource interface {
+	// GetMachines returns a list of machines from the source, along with a *partialError if some could not be retrieved
+	GetMachines(ctx context.Context) ([]*Machine, error)
+	// Watch returns a channel which is signalled when machines arrive or depart
+	Watch(stop chan struct{}) chan struct{}
+}
+
+// MultiSource ... merges the machines from one or more sources
+type MultiSource struct {
+	// the names of the sources, in order of precedence
+	names []string
+	// the machine sources
+	sources []MachineSource
+}
+
 // FleetInterface ... is the interface used to extract the machines from fleet cluster
 type FleetInterface struct {
 	// the http client
 	httpClient *http.Client
-	// the fleet client
-	fleetClient fleet.API
+	// the location of the fleet api
+	location url.URL
+}
+
+// EtcdInterface ... is the interface used to extract the machines directly from the fleet registry in etcd
+type EtcdInterface struct {
+	// the etcd keys api
+	keysAPI etcd.KeysAPI
+	// the fleet registry
+	registry *registry.EtcdRegistry
+	// the key prefix used by fleet
+	keyPrefix string
+}
+
+// InventoryInterface ... is the interface used to extract the machines from a static inventory file
+type InventoryInterface struct {
+	// the path to the inventory file
+	filename string
+	// the interval to check the file for changes
+	interval time.Duration
+}
+
+// DNSInterface ... is the interface used to discover the machines from dns srv or a records
+type DNSInterface struct {
+	// the srv record to discover the hosts from
+	srv string
+	// a list of hostnames to resolve
+	hosts []string
+	// a pattern used to extract metadata from the hostnames
+	pattern *regexp.Regexp
+	// static metadata added to all the machines
+	metadata map[string]string
+	// the resolver used to perform the lookups
+	resolver *net.Resolver
+	// the timeout for dns lookups
+	timeout time.Duration
+	// the interval to check dns for changes
+	interval time.Duration
+}
+
+// ConsulInterface ... is the interface used to extract the machines from the consul catalog
+type ConsulInterface struct {
+	// the consul endpoint
+	location *url.URL
+	// the http client used to list the machines
+	httpClient *http.Client
+	// the http client used for the blocking queries
+	watchClient *http.Client
+	// the maximum time to wait on a blocking query
+	wait time.Duration
+	// the time to wait before retrying a failed or non-blocking query
+	backoff time.Duration
+}
+
+// HostResolver ... resolves the machine addresses into hostnames, caching the results
+type HostResolver struct {
+	sync.Mutex
+	// the resolver used to perform the lookups
+	resolver *net.Resolver
+	// the timeout for dns lookups
+	timeout time.Duration
+	// the time to cache a resolution for, successful or not
+	cacheTTL time.Duration
+	// the cache of address to hostname
+	cache map[string]*cachedHostname
+}
+
+// MetadataMapper ... converts the machine metadata into node labels, annotations and spec fields
+type MetadataMapper struct {
+	// a prefix added to the label keys, i.e. fleet.coreos.com/
+	prefix string
+	// the metadata keys to rename
+	renames map[string]string
+	// the metadata keys to include, an empty list includes all
+	include []string
+	// the metadata keys to exclude
+	exclude []string
+	// lower case the label values
+	lowercase bool
+	// the metadata keys which are mapped to annotations
+	annotations []string
+	// a prefix added to the annotation keys
+	annotationPrefix string
+	// the metadata which marks the node as unschedulable
+	unschedulable map[string]string
+	// the rules used to map the metadata to taints
+	taints []taintRule
+}
+
+// RegisterStatus ... the state of the service exposed by the status api
+type RegisterStatus struct {
+	sync.RWMutex
+	// the time the service was started
+	started time.Time
+	// the time the last sync finished
+	lastSync time.Time
+	// the duration of the last sync
+	lastDuration time.Duration
+	// the number of syncs performed
+	syncs int
+	// the errors encountered in the current sync
+	errors []string
+	// the errors encountered in the last completed sync
+	lastErrors []string
+	// whether the machine source was reachable on the last sync
+	sourceReachable bool
+	// the verdicts of the machines seen, keyed by address
+	machines map[string]*machineStatus
+	// the recent health checks, keyed by node name
+	health map[string][]healthCheck
+}
+
+// ActionPlan ... the actions taken, or intended in a dry run, during a sync
+type ActionPlan struct {
+	sync.Mutex
+	// the actions in the order they were decided
+	actions []*plannedAction
+}
+
+// AuditLog ... an append only json lines log of the changes made to the nodes
+type AuditLog struct {
+	sync.Mutex
+	// the path of the log
+	filename string
+	// the size at which the log is rotated
+	maxSize int64
+	// the number of rotated logs to keep
+	maxBackups int
+	// the open log
+	file *os.File
+	// the current size of the log
+	size int64
+	// the log has been closed on shutdown
+	closed bool
+}
+
+// StatsdClient ... pushes the metrics to a statsd or dogstatsd endpoint
+type StatsdClient struct {
+	// the udp connection to the endpoint
+	conn net.Conn
+	// the prefix added to the metric names
+	prefix string
+	// the tags added to every metric
+	tags []string
+	// whether the endpoint supports dogstatsd tags
+	dogstatsd bool
+}
+
+// CycleTracer ... records the spans of each sync cycle and keeps the recent cycles
+type CycleTracer struct {
+	sync.RWMutex
+	// the cycle in progress
+	current *cycleReport
+	// the recent cycles, oldest first
+	history []*cycleReport
+	// the number of cycles to keep
+	size int
+	// the otlp/http collector the cycles are exported to
+	endpoint string
+	// the http client used to export the cycles
+	client *http.Client
+	// the exports in progress
+	exports sync.WaitGroup
 }
 
 // KubernetesInterface ... the interface to speak to the kubernetes api
 type KubernetesInterface struct {
-	// the kubernetes api
-	client *kube.Client
+	// the configuration for the kubernetes api
+	kubecfg kube.Config
+	// the kubernetes api version
+	version string
+	// log the changes rather than making them
+	dryRun bool
+	// the cluster reads the taints from the node spec rather than the annotation, i.e. kubernetes 1.6 onwards
+	specTaints bool
+	// the name of the cluster
+	cluster string
+}
+
+// ClusterTarget ... a kubernetes cluster the machines are registered into
+type ClusterTarget struct {
+	// the name of the cluster
+	Name string `json:"name"`
+	// the kubernetes api endpoint
+	API string `json:"api"`
+	// the kubernetes api version
+	APIVersion string `json:"api-version"`
+	// a token to use with the api
+	Token string `json:"token"`
+	// a file containing a token
+	TokenFile string `json:"token-file"`
+	// a kube cert file
+	Cert string `json:"cert"`
+	// insecure connection?
+	Insecure bool `json:"insecure"`
+	// the metadata used to route machines into the cluster, i.e. cluster=blue
+	Metadata string `json:"metadata"`
+	// the parsed metadata selector
+	selector map[string]string
+	// the kubernetes client for the cluster
+	kapi *KubernetesInterface
+}
+
+// Webhook ... an outgoing webhook notified of the changes made to the nodes
+type Webhook struct {
+	// the url the events are posted to
+	URL string `json:"url"`
+	// additional headers added to the request
+	Headers map[string]string `json:"headers"`
+	// the secret used to sign the payload with a hmac
+	Secret string `json:"secret"`
+	// the events sent to the webhook, all events if empty
+	Events []string `json:"events"`
+	// the number of attempts to deliver an event
+	Retries int `json:"retries"`
+	// the timeout of a delivery
+	Timeout string `json:"timeout"`
+	// the parsed timeout
+	timeout time.Duration
+	// the queue of events waiting to be delivered
+	queue chan *webhookEvent
+	// the http client used to deliver the events
+	client *http.Client
+	// the events queued or being delivered
+	pending sync.WaitGroup
 }
 
 // Machine ... the structure of a machine from fleet
 type Machine struct {
 	// the name of the machine - the ip address
 	Name string
+	// the unique id of the machine, i.e. the fleet machine id
+	ID string
+	// the version of fleet running on the machine
+	Version string
+	// all the addresses of the machine, the first being the public ip
+	Addresses []string
+	// the hostname resolved from dns
+	Hostname string
+	// an optional fixed name to register the node as
+	NodeName string
+	// the sources which reported the machine
+	Sources []string
 	// the metadata associated to the machine
 	Metadata map[string]string
+	// the labels to apply to the node
+	Labels map[string]string
+	// the annotations to apply to the node
+	Annotations map[string]string
+	// whether the node should be marked unschedulable
+	Unschedulable bool
+	// the taints to apply to the node
+	Taints []nodeTaint
+	// the machine spec reported by the kubelet
+	Spec *cadvisor.MachineInfo
 }
@@ -0,0 +1,138 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	etcd "github.com/coreos/etcd/client"
+	"github.com/coreos/fleet/pkg"
+	"github.com/coreos/fleet/registry"
+	"github.com/golang/glog"
+	netcontext "golang.org/x/net/context"
+)
+
+// NewEtcdInterface creates a new interface to read the machines directly from the fleet registry
+func NewEtcdInterface() (*EtcdInterface, error) {
+	glog.V(3).Infof("Creating a client to etcd, endpoints: %s, prefix: %s", config.etcdEndpoints, config.etcdPrefix)
+	service := new(EtcdInterface)
+
+	transport := &http.Transport{
+		Dial: (&net.Dialer{
+			Timeout: config.etcdTimeout,
+		}).Dial,
+	}
+
+	// step: are we using tls to speak to etcd?
+	if config.etcdCAFile != "" || config.etcdCertFile != "" {
+		tlsConfig, err := pkg.ReadTLSConfigFiles(config.etcdCAFile, config.etcdCertFile, config.etcdKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the etcd tls configuration, error: %s", err)
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	// step: create the etcd client
+	client, err := etcd.New(etcd.Config{
+		Endpoints:               strings.Split(config.etcdEndpoints, ","),
+		Transport:               transport,
+		HeaderTimeoutPerRequest: config.etcdTimeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the etcd client, error: %s", err)
+	}
+
+	service.keyPrefix = config.etcdPrefix
+	service.keysAPI = etcd.NewKeysAPI(client)
+	service.registry = registry.NewEtcdRegistry(service.keysAPI, config.etcdPrefix, config.etcdTimeout)
+
+	return service, nil
+}
+
+// GetMachines return a list of machines from the fleet registry
+func (r EtcdInterface) GetMachines(ctx context.Context) ([]*Machine, error) {
+	glog.V(5).Infof("Retrieving a list of the machines from the fleet registry")
+
+	// step: the registry applies its own timeout, we can only refuse to start once cancelled
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// step: get the list of machines
+	machines, err := r.registry.Machines()
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve a list of machines from etcd, error: %s", err)
+	}
+	// step: constructing a list of machine
+	var list []*Machine
+
+	for _, x := range machines {
+		glog.V(6).Infof("Adding the machine: %s to the list of fleet nodes", x)
+		list = append(list, newMachine(x))
+	}
+	glog.V(4).Infof("Found %d machine in the fleet registry", len(machines))
+	return list, nil
+}
+
+// Watch watches the fleet registry for machines arriving or departing
+func (r EtcdInterface) Watch(stop chan struct{}) chan struct{} {
+	updates := make(chan struct{}, 1)
+	key := path.Join(r.keyPrefix, "machines")
+
+	// step: cancel the watcher on stop
+	ctx, cancel := netcontext.WithCancel(netcontext.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	go func() {
+		watcher := r.keysAPI.Watcher(key, &etcd.WatcherOptions{Recursive: true})
+		for {
+			resp, err := watcher.Next(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					glog.V(4).Infof("Closing the etcd watcher on key: %s", key)
+					return
+				}
+				glog.Errorf("Failed to watch the key: %s in etcd, error: %s", key, err)
+				// step: we don't want to hammer etcd, wait and recreate the watcher
+				time.Sleep(config.etcdTimeout)
+				watcher = r.keysAPI.Watcher(key, &etcd.WatcherOptions{Recursive: true})
+				continue
+			}
+
+			// step: the machine states are refreshed periodically, we only care about arrivals and departures
+			switch resp.Action {
+			case "create", "delete", "expire":
+				glog.V(4).Infof("Machine change in the fleet registry, action: %s, key: %s", resp.Action, resp.Node.Key)
+				select {
+				case updates <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	return updates
+}
@@ -17,13 +17,15 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"net/http"
 	"net/url"
-	"time"
 
 	fleet "github.com/coreos/fleet/client"
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/pkg"
 	"github.com/golang/glog"
 )
 
@@ -38,58 +40,79 @@ func NewFleetInterface() (*FleetInterface, error) {
 		return nil, err
 	}
 
-	// step: ensure we are using a fleet socket
-	if location.Scheme != "unix" {
-		return nil, fmt.Errorf("the fleet endpoint should be a unix socket file, please read documentation")
+	// step: are we tunnelling to fleet via ssh?
+	dialer := &net.Dialer{Timeout: config.fleetTimeout}
+	dial := dialer.Dial
+	if config.fleetTunnel != "" {
+		tunnel, err := newSSHTunnel(config.fleetTunnel, config.fleetSSHUser, config.fleetSSHKey,
+			config.fleetKnownHosts, config.fleetStrictHostKeys, config.fleetTimeout)
+		if err != nil {
+			return nil, err
+		}
+		dial = tunnel.Dial
+	}
+
+	transport := &http.Transport{
+		DisableKeepAlives: true,
 	}
 
-	location.Scheme = "http"
-	location.Host = "domain-sock"
-	socketPath := location.Path
-	location.Path = ""
+	switch location.Scheme {
+	case "unix":
+		location.Scheme = "http"
+		location.Host = "domain-sock"
+		socketPath := location.Path
+		location.Path = ""
+		transport.Dial = func(network, addr string) (net.Conn, error) {
+			return dial("unix", socketPath)
+		}
+	case "http", "https":
+		transport.Dial = dial
+		// step: are we using tls to speak to fleet?
+		if location.Scheme == "https" {
+			transport.TLSClientConfig, err = pkg.ReadTLSConfigFiles(config.fleetCAFile, config.fleetCertFile, config.fleetKeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read the fleet tls configuration, error: %s", err)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("the fleet endpoint should be a unix socket, http or https url, please read documentation")
+	}
 
 	// step: create the http client
 	service.httpClient = &http.Client{
-		Timeout: time.Duration(10) * time.Second,
-		Transport: &http.Transport{
-			Dial: func(network, addr string) (net.Conn, error) {
-				return net.Dial("unix", socketPath)
-			},
-			DisableKeepAlives: true,
-		},
+		Timeout:   config.fleetTimeout,
+		Transport: transport,
 	}
 
-	// step: create the fleet client
-	service.fleetClient, err = fleet.NewHTTPClient(service.httpClient, *location)
-	if err != nil {
+	// step: check we can create the fleet client
+	if _, err = fleet.NewHTTPClient(service.httpClient, *location); err != nil {
 		return nil, fmt.Errorf("unable to create the fleet api client, error: %s", err)
 	}
+	service.location = *location
+
 	return service, nil
 }
 
-// GetMachine get my machine from fleet
-func (r FleetInterface) GetMachine() (*Machine, error) {
-	// step: get all the machines
-	machines, err := r.GetMachines()
-	if err != nil {
-		return nil, err
-	}
-	// step: iterate and find the machine
-	for _, machine := range machines {
-		if machine.Name == config.fleetIPAddress {
-			return machine, nil
-		}
+// client returns a fleet client, whose requests are cancelled when the context is done
+func (r FleetInterface) client(ctx context.Context) (fleet.API, error) {
+	httpClient := &http.Client{
+		Timeout:   r.httpClient.Timeout,
+		Transport: &contextTransport{ctx: ctx, next: r.httpClient.Transport},
 	}
 
-	return nil, fmt.Errorf("unable to find the machine: %s in the list of machines", config.fleetIPAddress)
+	return fleet.NewHTTPClient(httpClient, r.location)
 }
 
 // GetMachines return a list of machines from fleet
-func (r FleetInterface) GetMachines() ([]*Machine, error) {
+func (r FleetInterface) GetMachines(ctx context.Context) ([]*Machine, error) {
 	glog.V(5).Infof("Retrieving a list of the machines in the fleet cluster")
 
+	fleetClient, err := r.client(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the fleet api client, error: %s", err)
+	}
 	// step: get the list of machines
-	machines, err := r.fleetClient.Machines()
+	machines, err := fleetClient.Machines()
 	if err != nil {
 		return nil, fmt.Errorf("failed to retrieve a list of machines from fleet, error: %s", err)
 	}
@@ -97,13 +120,25 @@ func (r FleetInterface) GetMachines() ([]*Machine, error) {
 	var list []*Machine
 
 	for _, x := range machines {
-		machine := &Machine{
-			Name:     x.PublicIP,
-			Metadata: x.Metadata,
-		}
 		glog.V(6).Infof("Adding the machine: %s to the list of fleet nodes", x)
-		list = append(list, machine)
+		list = append(list, newMachine(x))
 	}
 	glog.V(4).Infof("Found %d machine in the fleet cluster", len(machines))
 	return list, nil
 }
+
+// Watch the fleet api does not provide a means to watch the machines
+func (r FleetInterface) Watch(stop chan struct{}) chan struct{} {
+	return nil
+}
+
+// newMachine converts the fleet machine state into a machine
+func newMachine(state machine.MachineState) *Machine {
+	return &Machine{
+		Name:      state.PublicIP,
+		ID:        state.ID,
+		Version:   state.Version,
+		Addresses: []string{state.PublicIP},
+		Metadata:  state.Metadata,
+	}
+}
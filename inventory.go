@@ -0,0 +1,202 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+)
+
+// inventoryMachine ... the structure of a machine in the inventory file
+type inventoryMachine struct {
+	// the ip address of the machine
+	Address string `json:"address"`
+	// an optional unique id for the machine
+	ID string `json:"id"`
+	// any additional addresses of the machine
+	Addresses []string `json:"addresses"`
+	// an optional fixed node name
+	Name string `json:"name"`
+	// the metadata associated to the machine
+	Metadata map[string]string `json:"metadata"`
+}
+
+// NewInventoryInterface creates a new interface to read the machines from a static inventory file
+func NewInventoryInterface() (*InventoryInterface, error) {
+	glog.V(3).Infof("Creating a static inventory source, file: %s", config.inventoryFile)
+	service := &InventoryInterface{
+		filename: config.inventoryFile,
+		interval: config.inventoryInterval,
+	}
+
+	// step: ensure we can read the inventory
+	if _, err := service.GetMachines(context.Background()); err != nil {
+		return nil, err
+	}
+
+	return service, nil
+}
+
+// GetMachines return a list of machines from the inventory file
+func (r InventoryInterface) GetMachines(ctx context.Context) ([]*Machine, error) {
+	glog.V(5).Infof("Retrieving a list of the machines from the inventory: %s", r.filename)
+
+	content, err := ioutil.ReadFile(r.filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the inventory file: %s, error: %s", r.filename, err)
+	}
+
+	var machines []inventoryMachine
+	switch strings.ToLower(filepath.Ext(r.filename)) {
+	case ".csv":
+		machines, err = decodeInventoryCSV(content)
+	default:
+		machines, err = decodeInventoryYAML(content)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode the inventory file: %s, error: %s", r.filename, err)
+	}
+
+	// step: constructing a list of machine
+	var list []*Machine
+	for index, x := range machines {
+		if x.Address == "" {
+			return nil, fmt.Errorf("the machine at index %d in the inventory does not have an address", index)
+		}
+		if x.Metadata == nil {
+			x.Metadata = make(map[string]string, 0)
+		}
+		glog.V(6).Infof("Adding the machine: %s to the list of inventory nodes", x.Address)
+		list = append(list, &Machine{
+			Name:      x.Address,
+			ID:        x.ID,
+			NodeName:  x.Name,
+			Addresses: append([]string{x.Address}, x.Addresses...),
+			Metadata:  x.Metadata,
+		})
+	}
+	glog.V(4).Infof("Found %d machine in the inventory", len(list))
+	return list, nil
+}
+
+// Watch polls the inventory file for changes
+func (r InventoryInterface) Watch(stop chan struct{}) chan struct{} {
+	updates := make(chan struct{}, 1)
+
+	go func() {
+		lastModified := r.modified()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(r.interval):
+			}
+			// step: has the file changed since we last looked?
+			if modified := r.modified(); !modified.Equal(lastModified) {
+				glog.V(3).Infof("The inventory file: %s has changed", r.filename)
+				lastModified = modified
+				select {
+				case updates <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	return updates
+}
+
+// modified returns the last modification time of the inventory
+func (r InventoryInterface) modified() time.Time {
+	stat, err := os.Stat(r.filename)
+	if err != nil {
+		glog.Errorf("Unable to stat the inventory file: %s, error: %s", r.filename, err)
+		return time.Time{}
+	}
+
+	return stat.ModTime()
+}
+
+// decodeInventoryYAML decodes a yaml or json inventory, i.e. machines: [{address: 10.0.0.1, metadata: {role: kubernetes}}]
+func decodeInventoryYAML(content []byte) ([]inventoryMachine, error) {
+	var inventory struct {
+		Machines []inventoryMachine `json:"machines"`
+	}
+	if err := yaml.Unmarshal(content, &inventory); err != nil {
+		return nil, err
+	}
+
+	return inventory.Machines, nil
+}
+
+// decodeInventoryCSV decodes a csv inventory, the header must contain an address column, optional
+// id and name columns and any other columns are taken as metadata
+func decodeInventoryCSV(content []byte) ([]inventoryMachine, error) {
+	records, err := csv.NewReader(bytes.NewReader(content)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) <= 0 {
+		return nil, nil
+	}
+
+	// step: check the header has an address column
+	header := records[0]
+	found := false
+	for i, column := range header {
+		header[i] = strings.TrimSpace(column)
+		if header[i] == "address" {
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("the csv header does not contain an address column")
+	}
+
+	var machines []inventoryMachine
+	for _, record := range records[1:] {
+		machine := inventoryMachine{Metadata: make(map[string]string, 0)}
+		for i, value := range record {
+			value = strings.TrimSpace(value)
+			switch header[i] {
+			case "address":
+				machine.Address = value
+			case "id":
+				machine.ID = value
+			case "name":
+				machine.Name = value
+			default:
+				if value != "" {
+					machine.Metadata[header[i]] = value
+				}
+			}
+		}
+		machines = append(machines, machine)
+	}
+
+	return machines, nil
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestInventoryGetMachines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inventory")
+	if err != nil {
+		t.Fatalf("unable to create a temporary directory, error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		Filename string
+		Content  string
+		Expected []*Machine
+		Error    bool
+	}{
+		{
+			Filename: "inventory.yaml",
+			Content: `
+machines:
+- address: 10.0.0.1
+  id: m1
+  name: worker-1
+  addresses: [10.1.0.1]
+  metadata:
+    role: kubernetes
+- address: 10.0.0.2
+`,
+			Expected: []*Machine{
+				{Name: "10.0.0.1", ID: "m1", NodeName: "worker-1", Addresses: []string{"10.0.0.1", "10.1.0.1"}, Metadata: map[string]string{"role": "kubernetes"}},
+				{Name: "10.0.0.2", Addresses: []string{"10.0.0.2"}, Metadata: map[string]string{}},
+			},
+		},
+		{
+			Filename: "inventory.json",
+			Content:  `{"machines": [{"address": "10.0.0.1", "id": "m1", "metadata": {"role": "kubernetes"}}]}`,
+			Expected: []*Machine{
+				{Name: "10.0.0.1", ID: "m1", Addresses: []string{"10.0.0.1"}, Metadata: map[string]string{"role": "kubernetes"}},
+			},
+		},
+		{
+			Filename: "inventory.csv",
+			Content:  "address, id, name, role, zone\n10.0.0.1,m1,worker-1,kubernetes,eu1\n10.0.0.2,,,kubernetes,\n",
+			Expected: []*Machine{
+				{Name: "10.0.0.1", ID: "m1", NodeName: "worker-1", Addresses: []string{"10.0.0.1"}, Metadata: map[string]string{"role": "kubernetes", "zone": "eu1"}},
+				{Name: "10.0.0.2", Addresses: []string{"10.0.0.2"}, Metadata: map[string]string{"role": "kubernetes"}},
+			},
+		},
+		{
+			Filename: "empty.csv",
+			Content:  "",
+		},
+		{
+			Filename: "missing-address.yaml",
+			Content:  "machines:\n- id: m1\n  metadata:\n    role: kubernetes\n",
+			Error:    true,
+		},
+		{
+			Filename: "missing-address.csv",
+			Content:  "address,id\n,m1\n",
+			Error:    true,
+		},
+		{
+			Filename: "no-address-column.csv",
+			Content:  "ip,id\n10.0.0.1,m1\n",
+			Error:    true,
+		},
+		{
+			Filename: "malformed.csv",
+			Content:  "address,\"id\nrole\n10.0.0.1,m1\n",
+			Error:    true,
+		},
+		{
+			Filename: "malformed.yaml",
+			Content:  "machines: [address: 10.0.0.1\n",
+			Error:    true,
+		},
+	}
+	for i, c := range cases {
+		filename := filepath.Join(dir, c.Filename)
+		if err := ioutil.WriteFile(filename, []byte(c.Content), 0644); err != nil {
+			t.Fatalf("unable to write the inventory, error: %s", err)
+		}
+		service := InventoryInterface{filename: filename}
+		machines, err := service.GetMachines(context.Background())
+		if c.Error {
+			if err == nil {
+				t.Errorf("case %d, file: %s, expected an error", i, c.Filename)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d, file: %s, unexpected error: %s", i, c.Filename, err)
+			continue
+		}
+		if !reflect.DeepEqual(machines, c.Expected) {
+			t.Errorf("case %d, file: %s, expected: %v, got: %v", i, c.Filename, c.Expected, machines)
+		}
+	}
+
+	// step: a missing inventory is an error
+	service := InventoryInterface{filename: filepath.Join(dir, "missing.yaml")}
+	if _, err := service.GetMachines(context.Background()); err == nil {
+		t.Errorf("expected an error for a missing inventory")
+	}
+}
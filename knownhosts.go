@@ -0,0 +1,250 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/golang/glog"
+	"golang.org/x/crypto/ssh"
+)
+
+// knownHosts ... the host keys read from an openssh known_hosts file
+type knownHosts struct {
+	// the file the keys were read from
+	filename string
+	// the entries read from the file
+	entries []*knownHost
+	// whether hosts missing from the file are accepted
+	acceptUnknown bool
+}
+
+// knownHost ... a single entry in the known_hosts file
+type knownHost struct {
+	// the host patterns, which may be hashed or negated
+	patterns []string
+	// the key is marked as @revoked
+	revoked bool
+	// the public key of the host
+	key ssh.PublicKey
+}
+
+// loadKnownHosts reads the known hosts file, with strict checking the file must exist and hosts must be in it
+func loadKnownHosts(filename string, strict bool) (*knownHosts, error) {
+	hosts := &knownHosts{
+		filename:      filename,
+		acceptUnknown: !strict,
+	}
+
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) && !strict {
+			glog.Warningf("The known hosts file: %s does not exist, accepting any unknown host keys", filename)
+			return hosts, nil
+		}
+		return nil, fmt.Errorf("unable to read the known hosts file: %s, error: %s", filename, err)
+	}
+	for number, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entry, err := parseKnownHost(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid entry in the known hosts file: %s, line: %d, error: %s", filename, number+1, err)
+		}
+		if entry != nil {
+			hosts.entries = append(hosts.entries, entry)
+		}
+	}
+
+	return hosts, nil
+}
+
+// parseKnownHost parses a line of the known hosts file, i.e. [marker] hosts keytype key [comment]
+func parseKnownHost(line string) (*knownHost, error) {
+	entry := new(knownHost)
+	fields := strings.Fields(line)
+	if strings.HasPrefix(fields[0], "@") {
+		switch fields[0] {
+		case "@revoked":
+			entry.revoked = true
+		case "@cert-authority":
+			glog.V(4).Infof("Ignoring the certificate authority in the known hosts file, certificates are not supported")
+			return nil, nil
+		default:
+			return nil, fmt.Errorf("unknown marker: %s", fields[0])
+		}
+		fields = fields[1:]
+	}
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("expected the hosts, key type and key")
+	}
+	content, err := base64.StdEncoding.DecodeString(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode the key, error: %s", err)
+	}
+	key, err := ssh.ParsePublicKey(content)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse the key, error: %s", err)
+	}
+	if key.Type() != fields[1] {
+		return nil, fmt.Errorf("the key type: %s does not match the key: %s", fields[1], key.Type())
+	}
+	entry.patterns = strings.Split(fields[0], ",")
+	entry.key = key
+
+	return entry, nil
+}
+
+// HostKeyCallback verifies the key presented by the host, failing if the key is unknown, differs or is revoked
+func (r *knownHosts) HostKeyCallback(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	names := knownHostNames(hostname, remote)
+	// step: a revoked key is refused regardless of any other entries
+	for _, entry := range r.entries {
+		if entry.revoked && entry.matches(names) && keysEqual(entry.key, key) {
+			return fmt.Errorf("the %s host key for: %s has been revoked", key.Type(), hostname)
+		}
+	}
+
+	found := false
+	for _, entry := range r.entries {
+		if entry.revoked || !entry.matches(names) {
+			continue
+		}
+		if keysEqual(entry.key, key) {
+			glog.V(4).Infof("The %s host key for: %s matches the known hosts file: %s", key.Type(), hostname, r.filename)
+			return nil
+		}
+		found = true
+	}
+	if found {
+		return fmt.Errorf("the %s host key for: %s does not match the known hosts file: %s, possible man in the middle attack",
+			key.Type(), hostname, r.filename)
+	}
+	if r.acceptUnknown {
+		glog.Warningf("The host: %s is not in the known hosts file: %s, accepting the %s host key", hostname, r.filename, key.Type())
+		return nil
+	}
+
+	return fmt.Errorf("the host: %s is not in the known hosts file: %s, please add the host key, i.e. ssh-keyscan", hostname, r.filename)
+}
+
+// matches checks if any of the names match the host patterns of the entry
+func (r *knownHost) matches(names []string) bool {
+	matched := false
+	for _, pattern := range r.patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimPrefix(pattern, "!")
+		for _, name := range names {
+			if !matchHostPattern(pattern, name) {
+				continue
+			}
+			// step: a negated pattern excludes the host from the entry
+			if negated {
+				return false
+			}
+			matched = true
+		}
+	}
+
+	return matched
+}
+
+// knownHostNames returns the names the host is known by, i.e. host or [host]:port, along with the remote ip
+func knownHostNames(hostname string, remote net.Addr) []string {
+	format := func(host, port string) string {
+		if port == "" || port == "22" {
+			return host
+		}
+		return fmt.Sprintf("[%s]:%s", host, port)
+	}
+
+	host, port, err := net.SplitHostPort(hostname)
+	if err != nil {
+		host, port = hostname, ""
+	}
+	host = strings.ToLower(host)
+	names := []string{format(host, port)}
+	if address, ok := remote.(*net.TCPAddr); ok && address.IP.String() != host {
+		names = append(names, format(address.IP.String(), port))
+	}
+
+	return names
+}
+
+// matchHostPattern matches the host against a known hosts pattern, either hashed or containing * and ? wildcards
+func matchHostPattern(pattern, host string) bool {
+	if strings.HasPrefix(pattern, "|1|") {
+		parts := strings.Split(pattern, "|")
+		if len(parts) != 4 {
+			return false
+		}
+		salt, err := base64.StdEncoding.DecodeString(parts[2])
+		if err != nil {
+			return false
+		}
+		hash, err := base64.StdEncoding.DecodeString(parts[3])
+		if err != nil {
+			return false
+		}
+		mac := hmac.New(sha1.New, salt)
+		mac.Write([]byte(host))
+		return hmac.Equal(mac.Sum(nil), hash)
+	}
+
+	return matchWildcard(pattern, host)
+}
+
+// matchWildcard matches the value against a pattern where * matches any run of characters and ? any single character
+func matchWildcard(pattern, value string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(value); i >= 0; i-- {
+				if matchWildcard(pattern[1:], value[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(value) == 0 {
+				return false
+			}
+		default:
+			if len(value) == 0 || pattern[0] != value[0] {
+				return false
+			}
+		}
+		pattern, value = pattern[1:], value[1:]
+	}
+
+	return len(value) == 0
+}
+
+// keysEqual checks if the two public keys are the same
+func keysEqual(a, b ssh.PublicKey) bool {
+	return bytes.Equal(a.Marshal(), b.Marshal())
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestHostKey(t *testing.T) ssh.PublicKey {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate a key, error: %s", err)
+	}
+	key, err := ssh.NewPublicKey(&private.PublicKey)
+	if err != nil {
+		t.Fatalf("unable to create the public key, error: %s", err)
+	}
+
+	return key
+}
+
+func writeKnownHosts(t *testing.T, lines ...string) string {
+	dir, err := ioutil.TempDir("", "known_hosts")
+	if err != nil {
+		t.Fatalf("unable to create a temporary directory, error: %s", err)
+	}
+	filename := filepath.Join(dir, "known_hosts")
+	if err := ioutil.WriteFile(filename, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatalf("unable to write the known hosts file, error: %s", err)
+	}
+
+	return filename
+}
+
+func knownHostLine(hosts string, key ssh.PublicKey) string {
+	return fmt.Sprintf("%s %s", hosts, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))))
+}
+
+func hashedHost(host string) string {
+	salt := []byte("0123456789abcdefghij")
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(host))
+	return fmt.Sprintf("|1|%s|%s", base64.StdEncoding.EncodeToString(salt), base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+func TestKnownHostsCallback(t *testing.T) {
+	key, other := newTestHostKey(t), newTestHostKey(t)
+	remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 22}
+	filename := writeKnownHosts(t,
+		"# a comment",
+		knownHostLine("bastion.example.com,10.0.0.1", key),
+		knownHostLine("[jump.example.com]:2222", key),
+		knownHostLine(hashedHost("hashed.example.com"), key),
+		knownHostLine("*.wild.example.com,!bad.wild.example.com", key),
+		knownHostLine("changed.example.com", other),
+		"@revoked "+knownHostLine("revoked.example.com", key),
+		knownHostLine("revoked.example.com", key),
+	)
+	defer os.RemoveAll(filepath.Dir(filename))
+
+	hosts, err := loadKnownHosts(filename, true)
+	if err != nil {
+		t.Fatalf("unable to load the known hosts, error: %s", err)
+	}
+	cases := []struct {
+		Hostname string
+		Remote   net.Addr
+		OK       bool
+	}{
+		{Hostname: "bastion.example.com:22", Remote: remote, OK: true},
+		{Hostname: "BASTION.example.com:22", OK: true},
+		{Hostname: "jump.example.com:2222", OK: true},
+		{Hostname: "jump.example.com:22"},
+		{Hostname: "hashed.example.com:22", OK: true},
+		{Hostname: "a.wild.example.com:22", OK: true},
+		{Hostname: "bad.wild.example.com:22"},
+		{Hostname: "changed.example.com:22"},
+		{Hostname: "revoked.example.com:22"},
+		{Hostname: "unknown.example.com:22"},
+		{Hostname: "unknown.example.com:22", Remote: remote, OK: true},
+	}
+	for i, c := range cases {
+		err := hosts.HostKeyCallback(c.Hostname, c.Remote, key)
+		if c.OK && err != nil {
+			t.Errorf("case %d, host: %s should have been accepted, error: %s", i, c.Hostname, err)
+		}
+		if !c.OK && err == nil {
+			t.Errorf("case %d, host: %s should have been refused", i, c.Hostname)
+		}
+	}
+}
+
+func TestKnownHostsUnknown(t *testing.T) {
+	key := newTestHostKey(t)
+	missing := filepath.Join(os.TempDir(), "node-register-missing-known-hosts")
+	if _, err := loadKnownHosts(missing, true); err == nil {
+		t.Errorf("a missing known hosts file should fail with strict checking")
+	}
+	hosts, err := loadKnownHosts(missing, false)
+	if err != nil {
+		t.Fatalf("a missing known hosts file should be accepted without strict checking, error: %s", err)
+	}
+	if err := hosts.HostKeyCallback("new.example.com:22", nil, key); err != nil {
+		t.Errorf("an unknown host should be accepted without strict checking, error: %s", err)
+	}
+
+	filename := writeKnownHosts(t, knownHostLine("new.example.com", newTestHostKey(t)))
+	defer os.RemoveAll(filepath.Dir(filename))
+	if hosts, err = loadKnownHosts(filename, false); err != nil {
+		t.Fatalf("unable to load the known hosts, error: %s", err)
+	}
+	if err := hosts.HostKeyCallback("new.example.com:22", nil, key); err == nil {
+		t.Errorf("a changed host key should be refused without strict checking")
+	}
+}
+
+func TestKnownHostsInvalid(t *testing.T) {
+	key := newTestHostKey(t)
+	for _, line := range []string{
+		"host.example.com ssh-rsa",
+		"host.example.com ssh-rsa !!notbase64!!",
+		"@unknown " + knownHostLine("host.example.com", key),
+		"host.example.com ssh-rsa " + strings.Fields(knownHostLine("x", key))[2],
+	} {
+		filename := writeKnownHosts(t, line)
+		if _, err := loadKnownHosts(filename, true); err == nil {
+			t.Errorf("the line: %q should have been refused", line)
+		}
+		os.RemoveAll(filepath.Dir(filename))
+	}
+}
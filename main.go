@@ -17,19 +17,35 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"fmt"
-	"net"
 	"net/http"
 	"os"
-	"os/signal"
-	"syscall"
+	"strings"
 	"time"
 
 	"github.com/golang/glog"
 )
 
 var (
-	kapi *KubernetesInterface
+	// the kubernetes clusters we are registering machines into
+	clusters []*ClusterTarget
+	// the resolver used to convert the machine addresses into hostnames
+	hostResolver *HostResolver
+	// the mapper used to convert the machine metadata into labels
+	metadataMapper *MetadataMapper
+	// the state of the service exposed by the status api
+	syncStatus = NewRegisterStatus()
+	// the actions taken, or intended in a dry run, in the current sync
+	syncPlan = new(ActionPlan)
+	// the audit log of the changes made to the nodes
+	auditLog *AuditLog
+	// the webhooks notified of the changes made to the nodes
+	webhooks []*Webhook
+	// the client used to push the metrics to statsd, nil when disabled
+	statsd *StatsdClient
+	// the tracer recording the spans of each sync cycle
+	cycleTracer *CycleTracer
 )
 
 func main() {
@@ -41,181 +57,493 @@ func main() {
 
 	glog.Infof("Starting the Node Register Service, version: %s, git+sha: %s", Version, GitSha)
 
-	// step: create a fleet api interface
-	fleet, err := NewFleetInterface()
+	// step: create the tracer for the sync cycles
+	cycleTracer = NewCycleTracer(config.cycleHistory, config.otlpEndpoint)
+
+	// step: create the resolver for the node names
+	hostResolver = NewHostResolver()
+
+	// step: create the machine source
+	source, err := NewMachineSource(config.machineSources)
 	if err != nil {
-		glog.Errorf("Failed to create fleet api, error: %s", err)
+		glog.Errorf("Failed to create the machine source: %s, error: %s", config.machineSource, err)
+		os.Exit(1)
+	}
+
+	// step: create the label mapper
+	if metadataMapper, err = NewMetadataMapper(); err != nil {
+		glog.Errorf("Invalid label options, error: %s", err)
 		os.Exit(1)
 	}
 
-	// step: create a client to the kubernetes api
-	kapi, err = NewKubernetesInterface()
+	// step: open the audit log
+	if config.auditFile != "" {
+		if auditLog, err = NewAuditLog(config.auditFile, config.auditMaxSize*1024*1024, config.auditMaxBackups); err != nil {
+			glog.Errorf("Failed to open the audit log, error: %s", err)
+			os.Exit(1)
+		}
+	}
+
+	// step: create the statsd client
+	if config.statsdAddress != "" {
+		tags := append(parseStatsdTags(config.statsdTags), "instance:"+config.instanceID)
+		if statsd, err = NewStatsdClient(config.statsdAddress, config.statsdPrefix, tags, config.dogstatsd); err != nil {
+			glog.Errorf("Failed to create the statsd client, error: %s", err)
+			os.Exit(1)
+		}
+	}
+
+	// step: start the webhooks
+	if config.webhooksFile != "" {
+		if webhooks, err = loadWebhooks(config.webhooksFile); err != nil {
+			glog.Errorf("Invalid webhooks, error: %s", err)
+			os.Exit(1)
+		}
+		for _, webhook := range webhooks {
+			webhook.Start()
+		}
+	}
+
+	// step: create a client to the kubernetes api for each of the clusters
+	for _, cluster := range clusters {
+		cluster.kapi, err = NewKubernetesInterface(cluster)
+		if err != nil {
+			glog.Errorf("Failed to create a kubernetes client, cluster: %s, endpoint: %s, error: %s",
+				cluster.Name, cluster.API, err)
+			os.Exit(1)
+		}
+	}
+
+	// step: determine where each of the clusters reads the taints from
+	for _, cluster := range clusters {
+		cluster.kapi.specTaints = taintsInSpec(context.Background(), cluster)
+	}
+
+	// step: cancel any in-flight requests on a shutdown signal
+	ctx, cancel := context.WithCancel(context.Background())
+	handleSignals(cancel)
+
+	// step: run the subcommand
+	err = runCommand(ctx, source)
+	shutdown()
 	if err != nil {
-		glog.Errorf("Failed to create a kubernetes client, endpoint: %s, error: %s", config.kubeAPI, err)
+		glog.Errorf("Failed to run the command: %s, error: %s", config.command, err)
 		os.Exit(1)
 	}
+}
 
-	// step: create the channel to termination requests
-	signalChannel := make(chan os.Signal)
-	signal.Notify(signalChannel, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+// runDaemon ... runs the sync loop until the context is cancelled by a shutdown signal
+func runDaemon(ctx context.Context, source MachineSource) {
+	// step: start the status api
+	if config.listen != "" {
+		serveStatus(config.listen)
+	}
+
+	// step: watch the source for any machine changes
+	stop := make(chan struct{})
+	defer close(stop)
+	machineChanges := source.Watch(stop)
+
+	// step: ping the systemd watchdog if requested
+	startWatchdog()
 
 	for {
-		// step: are we working standalone or working for ourselve?
-		if !config.standalone {
-			// step: retrieve a list of machines and filter them to
-			machines, err := fleet.GetMachines()
-			if err != nil {
-				glog.Errorf("Failed to retrieve a list of machines from fleet, error: %s", err)
-				// step: jump to the next run
+		started := time.Now()
+		syncMachines(ctx, source)
+		if ctx.Err() != nil {
+			glog.Infof("The sync was interrupted by a shutdown, exiting")
+			return
+		}
+		notifyCycle(started)
+		if config.dryRun {
+			logPlan()
+		}
+
+		// wait for either a timer or a signal
+		select {
+		case <-ctx.Done():
+			glog.Infof("Recieved a shutdown signal, exiting")
+			return
+		case <-machineChanges:
+			glog.V(3).Infof("Machines have changed in %s, performing a sync", config.machineSource)
+		case <-time.After(config.timeInterval):
+		}
+	}
+}
+
+// syncMachines ... performs a single pass, registering the machines and reaping the failed nodes
+func syncMachines(ctx context.Context, source MachineSource) {
+	started := time.Now()
+	reachable := true
+	syncStatus.BeginSync()
+	syncPlan.Reset()
+	cycleTracer.Begin()
+	defer cycleTracer.End()
+	watchdog.Begin()
+	defer watchdog.End()
+	// step: are we working standalone or working for ourselve?
+	if !config.standalone {
+		// step: retrieve a list of machines and filter them to
+		span := cycleTracer.Start("source.list", "sources", strings.Join(config.machineSources, ","))
+		machines, err := source.GetMachines(ctx)
+		span.End(err)
+		if partial, found := isPartialError(err); found {
+			// step: some of the sources failed, we still register the machines from the others
+			for _, x := range partial.errors {
+				syncStatus.RecordError(x)
 			}
+			err = nil
+		}
+		if err != nil {
+			glog.Errorf("Failed to retrieve a list of machines from %s, error: %s", config.machineSource, err)
+			syncStatus.RecordError(err)
+			reachable = false
+		} else {
+			statsd.Gauge("machines", int64(len(machines)))
 			// step: register the machines with kubernetes
-			registerMachines(machines)
+			registerMachines(ctx, machines)
+		}
 
+	} else {
+		// step: grab our machine from
+		span := cycleTracer.Start("source.get", "address", config.fleetIPAddress)
+		machine, err := getMachine(ctx, source, config.fleetIPAddress)
+		span.End(err)
+		if err != nil {
+			glog.Errorf("Failed to retrieve our machine from %s error: %s", config.machineSource, err)
+			syncStatus.RecordError(err)
+			reachable = false
 		} else {
-			// step: grab our machine from
-			if machine, err := fleet.GetMachine(); err != nil {
-				glog.Errorf("Failed to retrieve our machine from fleet error: %s", err)
-			} else {
-				// step: register with kubernetes
-				registerMachine(machine)
-			}
+			// step: register with kubernetes
+			registerMachines(ctx, []*Machine{machine})
 		}
+	}
 
-		// step: are we reaping nodes?
-		if config.kubeNodeRepear {
-			// step: grab a list of nodes from kubernetes
-			err := reapNodes()
-			if err != nil {
-				glog.Errorf("Failed to reap the nodes, error: %s", err)
+	// step: are we reaping nodes? - there is no point starting if we are shutting down
+	if config.kubeNodeRepear && ctx.Err() == nil {
+		// step: reap each of the clusters independently
+		for _, cluster := range clusters {
+			if err := reapNodes(ctx, cluster); err != nil {
+				glog.Errorf("Failed to reap the nodes in cluster: %s, error: %s", cluster.Name, err)
+				syncStatus.RecordError(err)
 			}
 		}
+	}
+	syncStatus.EndSync(started, reachable)
+	statsd.Count("sync.cycles", 1)
+	statsd.Count("sync.errors", int64(len(syncStatus.Errors())))
+	statsd.Timing("sync.duration", time.Since(started))
+}
 
-		// wait for either a timer or a signal
-		select {
-		case <-signalChannel:
-			glog.Infof("Recieved a shutdown signal, exiting")
-			os.Exit(0)
-		case <-time.After(config.timeInterval):
+// logPlan ... logs the actions which would have been taken in the last sync
+func logPlan() {
+	for _, x := range syncPlan.Actions() {
+		if x.Action == actionSkip {
+			glog.V(3).Infof("[dry-run] %s, cluster: %s, machine: %s, reason: %s", x.Action, x.Cluster, x.Address, x.Reason)
+			continue
 		}
+		glog.Infof("[dry-run] %s, cluster: %s, node: %s, reason: %s, changes: %d", x.Action, x.Cluster, x.Node, x.Reason, len(x.Changes))
 	}
 }
 
 // reapNodes() ... remove any nodes which haven't updated for a while
-func reapNodes() error {
-	nodes, err := kapi.GetFailedNodes()
+func reapNodes(ctx context.Context, cluster *ClusterTarget) error {
+	span := cycleTracer.Start("kubernetes.list_failed", "cluster", cluster.Name)
+	nodes, err := cluster.kapi.GetFailedNodes(ctx)
+	span.End(err)
 	if err != nil {
 		return fmt.Errorf("unable to retrieve the nodes from kubernetes, error: %s", err)
 	}
 
 	glog.V(4).Infof("Found %d nodes in a failed state", len(nodes))
 	for _, x := range nodes {
+		if ctx.Err() != nil {
+			return fmt.Errorf("reaping the nodes was interrupted, error: %s", ctx.Err())
+		}
 		condition := x.Status.Conditions[0]
 		timePassed := time.Since(condition.LastHeartbeatTime.Time)
 		glog.V(5).Infof("Node: %s has been down for %s", x.Name, timePassed)
 		if timePassed > config.kubeNodeDowntime {
 			glog.V(3).Infof("The node: %s has been down for %s, removing the node now", x.Name, timePassed)
-			if err := kapi.DeleteNode(x.Name); err != nil {
+			reason := fmt.Sprintf("the node has been down for %s", timePassed-timePassed%time.Second)
+			if err := cluster.kapi.DeleteNode(ctx, x.Name, reason); err != nil {
 				glog.Errorf("unable to remove the node: %s from kubernetes, error: %s", x.Name, err)
+				continue
+			}
+			// step: a dry run has not removed the node
+			if !config.dryRun {
+				statsd.Count("reaps", 1, "cluster:"+cluster.Name)
 			}
+			recordAction(&plannedAction{
+				Action:    actionReap,
+				Cluster:   cluster.Name,
+				Node:      x.Name,
+				MachineID: x.Annotations[annotationMachineID],
+				Reason:    reason,
+			})
 		}
 	}
 
 	return nil
 }
 
-// registerMachines ... a wrapper for multiple machine registrations
-func registerMachines(machines []*Machine) error {
+// countRegistration ... counts the verdict of a registration, a dry run makes no changes so they are not counted
+func countRegistration(cluster *ClusterTarget, verdict string) {
+	switch verdict {
+	case verdictRegistered, verdictReplaced, verdictUpdated:
+		if config.dryRun {
+			return
+		}
+	}
+	statsd.Count("registrations", 1, "cluster:"+cluster.Name, "verdict:"+verdict)
+}
+
+// registerMachines ... a wrapper for multiple machine registrations, routing each to a cluster
+func registerMachines(ctx context.Context, machines []*Machine) error {
 	for _, machine := range machines {
-		if err := registerMachine(machine); err != nil {
-			glog.Errorf("Failed to register machine: %s, error: %s", machine.Name, err)
+		// step: stop registering machines if we are shutting down
+		if ctx.Err() != nil {
+			glog.Warningf("Shutting down, skipping the registration of the remaining machines")
+			return ctx.Err()
+		}
+		address := machine.Name
+		// step: find the cluster the machine belongs to
+		cluster := routeMachine(machine)
+		if cluster == nil {
+			glog.V(5).Infof("Skipping machine: %s, does not match any of the clusters", machine.Name)
+			syncStatus.RecordMachine(address, "", machine, verdictUnrouted, "does not match any of the clusters")
+			recordAction(&plannedAction{Action: actionSkip, Address: address, Reason: "does not match any of the clusters"})
+			continue
+		}
+		verdict, err := registerMachine(ctx, cluster, machine)
+		if err != nil {
+			glog.Errorf("Failed to register machine: %s in cluster: %s, error: %s", machine.Name, cluster.Name, err)
+			syncStatus.RecordError(err)
+			countRegistration(cluster, verdict)
+			syncStatus.RecordMachine(address, cluster.Name, machine, verdict, err.Error())
+			recordAction(&plannedAction{Action: actionSkip, Cluster: cluster.Name, Address: address, Reason: err.Error()})
+			continue
+		}
+		countRegistration(cluster, verdict)
+		syncStatus.RecordMachine(address, cluster.Name, machine, verdict, "")
+		switch verdict {
+		case verdictFiltered, verdictSkipped:
+			recordAction(&plannedAction{Action: actionSkip, Cluster: cluster.Name, Address: address, Reason: verdict})
 		}
 	}
 
 	return nil
 }
 
-// registerMachine() ... register the machine with Kubernetes.
-//  a) the machine must match the tag filter on the metadata
+// registerMachine() ... register the machine with the Kubernetes cluster it was routed to, returning the verdict reached.
+//  a) the machine must match the metadata selector of the cluster
 //  b) we only register only if the node is responding as healthy
 // 	c) if the node is already registered, we will ONLY register is the node is matched as NodeNotReady (this aides with auto scaling groups)
-func registerMachine(machine *Machine) error {
+func registerMachine(ctx context.Context, cluster *ClusterTarget, machine *Machine) (string, error) {
 	var err error
+	var reason string
 
+	address := machine.Name
 	registeredName := machine.Name
 
 	// step: are we using dns hostname
 	if config.dnsResolve {
-		hostNames, err := net.LookupAddr(machine.Name)
+		span := cycleTracer.Start("dns.resolve", "address", machine.Name)
+		hostname, skip, err := resolveHostname(ctx, machine.Name)
+		span.End(err)
 		if err != nil {
 			glog.Errorf("failed to resolve the ip address: %s, error: %s", machine.Name, err)
-			return err
+			return verdictFailed, err
+		}
+		if skip {
+			return verdictSkipped, nil
+		}
+		registeredName = hostname
+		if hostname != machine.Name {
+			machine.Hostname = hostname
 		}
-		registeredName = hostNames[0]
 	}
-
-	// step: does the tag exist in the metadata
-	if _, found := machine.Metadata[config.tagName]; !found {
-		glog.V(5).Infof("Skippng machine: '%s', does not have '%s' tag in metadata", machine.Name, config.tagName)
-		return nil
+	// step: are we using a template for the node name?
+	if config.nameTemplate != nil {
+		if registeredName, err = renderNodeName(config.nameTemplate, machine.Name, machine); err != nil {
+			return verdictFailed, err
+		}
+	}
+	// step: does the machine have a fixed name
+	if machine.NodeName != "" {
+		registeredName = machine.NodeName
 	}
-	// step: is the value of the tag correct?
-	if tag, _ := machine.Metadata[config.tagName]; tag != config.tagValue {
-		glog.V(5).Infof("Skipping machine: %s, machine tag value: '%s' not equal to '%s'", machine.Name, tag, config.tagValue)
-		return nil
+	// step: ensure the name is a legal node name
+	if err := validateNodeName(registeredName); err != nil {
+		return verdictFailed, err
+	}
+
+	// step: does the machine match the cluster selector
+	if !cluster.Matches(machine) {
+		return verdictFiltered, nil
 	}
 
 	// step: check to see if the node is healthy
-	if health := nodeHealthy(machine.Name); !health {
-		return fmt.Errorf("the machine: %s is marked as unhealthy, skipping the node for now", machine.Name)
+	checked := time.Now()
+	span := cycleTracer.Start("healthz", "address", machine.Name)
+	health := nodeHealthy(ctx, machine.Name)
+	if health {
+		span.End(nil)
+	} else {
+		span.End(fmt.Errorf("the machine: %s is unhealthy", machine.Name))
+	}
+	syncStatus.RecordHealth(registeredName, health)
+	statsd.Count("health_checks", 1, "cluster:"+cluster.Name, fmt.Sprintf("healthy:%t", health))
+	statsd.Timing("health_check.duration", time.Since(checked), "cluster:"+cluster.Name)
+	if !health {
+		return verdictUnhealthy, fmt.Errorf("the machine: %s is marked as unhealthy, skipping the node for now", machine.Name)
+	}
+	// step: retrieve the machine spec from the kubelet
+	if config.kubeletSpec {
+		if machine.Spec, err = machineSpec(ctx, machine.Name); err != nil {
+			glog.Warningf("Unable to retrieve the machine spec, %s", err)
+		}
 	}
 
 	// step: update the name if required
 	machine.Name = registeredName
 
-	// step: add in the environment variables before registration
+	// step: map the metadata to labels and add in the environment variables before registration
+	machine.Labels = metadataMapper.Labels(machine.Metadata)
+	machine.Annotations = metadataMapper.Annotations(machine.Metadata)
+	machine.Unschedulable = metadataMapper.Unschedulable(machine.Metadata)
+	machine.Taints = metadataMapper.Taints(machine.Metadata)
+	if machine.Spec != nil {
+		if size := instanceSize(config.capacityBuckets, machine.Spec); size != "" {
+			machine.Labels[config.sizeLabel] = size
+		}
+	}
 	for name, value := range config.labels {
-		machine.Metadata[name] = value
+		machine.Labels[name] = value
 	}
 
 	// step: check if the node is registered
-	node, registered, err := kapi.IsRegistered(machine.Name)
+	node, registered, err := cluster.kapi.IsRegistered(ctx, machine.Name)
 	if err != nil {
-		return fmt.Errorf("Unable to check if machine: %s is registered in kubernetes, error: %s", machine.Name, err)
+		return verdictFailed, fmt.Errorf("Unable to check if machine: %s is registered in kubernetes, error: %s", machine.Name, err)
 	}
 
 	// step: is the node already registered?
+	var taints *nodeTaints
 	if registered {
 		nodeStatus := node.Status.Conditions[0].Type
 		glog.V(4).Infof("Node: %s already register, status: %s", node.Name, nodeStatus)
 
+		// step: has the address been reused by a different machine?
+		reason = fmt.Sprintf("the node is in a %s state", nodeStatus)
+		reused := false
+		if registeredID := node.Annotations[annotationMachineID]; registeredID != "" && machine.ID != "" && registeredID != machine.ID {
+			glog.Warningf("Node: %s was registered by machine: %s, the address is now used by machine: %s, replacing the node",
+				node.Name, registeredID, machine.ID)
+			reason = fmt.Sprintf("the address has been reused by machine: %s", machine.ID)
+			reused = true
+		}
+
 		// step: the node is already registered with kubernetes - the default behaviour is to
 		// check if the node status is running;
-		if nodeStatus == "Ready" {
+		if nodeStatus == "Ready" && !reused {
 			glog.V(4).Infof("Node: %s is in a running state, refusing to register a node in a running state", node.Name)
-			return nil
+			// step: read the spec taints if we have any to reconcile, the vendored api does not decode them
+			if cluster.kapi.specTaints && (len(machine.Taints) > 0 || node.Annotations[annotationManagedTaints] != "") {
+				if taints, err = cluster.kapi.GetTaints(ctx, node.Name); err != nil {
+					return verdictFailed, fmt.Errorf("Unable to retrieve the taints of the node: %s, error: %s", node.Name, err)
+				}
+			}
+			// step: keep the annotations and spec fields in sync with the metadata
+			before := nodeFields(node, taints)
+			if applyMachine(node, machine, taints) {
+				changes := diffFields(before, nodeFields(node, taints))
+				if err := cluster.kapi.UpdateNode(ctx, node.Name, changes, taints, "the machine metadata has changed"); err != nil {
+					return verdictFailed, fmt.Errorf("Failed to update the node: %s in kubernetes, error: %s", node.Name, err)
+				}
+				recordAction(&plannedAction{
+					Action:    actionRelabel,
+					Cluster:   cluster.Name,
+					Node:      node.Name,
+					Address:   address,
+					MachineID: machine.ID,
+					Reason:    "the machine metadata has changed",
+					Changes:   changes,
+				})
+				return verdictUpdated, nil
+			}
+			return verdictUnchanged, nil
 		}
 
 		glog.V(4).Infof("Deleting the node: %s and registering it later", node.Name)
+		// step: keep the spec taints so the node can be restored as it was
+		if cluster.kapi.specTaints {
+			if taints, err = cluster.kapi.GetTaints(ctx, node.Name); err != nil {
+				return verdictFailed, fmt.Errorf("Unable to retrieve the taints of the node: %s, error: %s", node.Name, err)
+			}
+		}
 		// step: we delete and update node
-		if err := kapi.DeleteNode(machine.Name); err != nil {
-			return fmt.Errorf("Failed to delete the node: %s from kubernetes, error: %s", machine.Name, err)
+		if err := cluster.kapi.DeleteNode(ctx, machine.Name, reason); err != nil {
+			return verdictFailed, fmt.Errorf("Failed to delete the node: %s from kubernetes, error: %s", machine.Name, err)
 		}
 	}
 
 	// step: register the node in kubernetes
-	if err := kapi.RegisterNode(machine); err != nil {
-		return fmt.Errorf("Failed to register the node, error: %s", err)
+	if !registered {
+		reason = "the node is not registered"
+	}
+	registerCtx := ctx
+	if registered {
+		// step: having deleted the node we must recreate it, even if we are shutting down
+		var cancel context.CancelFunc
+		registerCtx, cancel = shutdownDeadline.Context(config.shutdownTimeout)
+		defer cancel()
+	}
+	if err := cluster.kapi.RegisterNode(registerCtx, machine, reason); err != nil {
+		if !registered {
+			return verdictFailed, fmt.Errorf("Failed to register the node, error: %s", err)
+		}
+		// step: roll back the deletion, restoring the node as it was
+		glog.Errorf("Failed to recreate the deleted node: %s, restoring the original, error: %s", machine.Name, err)
+		rollbackCtx, cancel := shutdownDeadline.Context(config.shutdownTimeout)
+		defer cancel()
+		if rerr := cluster.kapi.RestoreNode(rollbackCtx, node, taints, "rolling back the failed replacement of the node"); rerr != nil {
+			return verdictFailed, fmt.Errorf("Failed to recreate the deleted node: %s, and unable to restore the original, error: %s, %s", machine.Name, err, rerr)
+		}
+		return verdictFailed, fmt.Errorf("Failed to recreate the deleted node: %s, the original has been restored, error: %s", machine.Name, err)
+	}
+	created, createdTaints := cluster.kapi.newNode(machine)
+	action := &plannedAction{
+		Action:    actionRegister,
+		Cluster:   cluster.Name,
+		Node:      machine.Name,
+		Address:   address,
+		MachineID: machine.ID,
+		Reason:    reason,
+		Changes:   diffFields(nodeFields(node, taints), nodeFields(created, createdTaints)),
+	}
+	if registered {
+		action.Action = actionRecreate
+	}
+	recordAction(action)
+	if registered {
+		return verdictReplaced, nil
 	}
 
-	return nil
+	return verdictRegistered, nil
 }
 
 // nodeHealthy checks to see if the node in a healthy condition
-func nodeHealthy(hostname string) bool {
+func nodeHealthy(ctx context.Context, hostname string) bool {
 	glog.V(4).Infof("Checking if the node: %s is in a healthy condition on port: %d", hostname, config.kubeHealthPort)
 	// step: call the /healthz url
 	url := fmt.Sprintf("http://%s:%d/healthz", hostname, config.kubeHealthPort)
-	response, err := http.Get(url)
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		glog.Errorf("Unable to check the health of the node: %s, error: %s", hostname, err)
+		return false
+	}
+	response, err := http.DefaultClient.Do(request.WithContext(ctx))
 	if err != nil {
 		glog.Errorf("Unable to check the health of the node: %s, error: %s", hostname, err)
 		return false
@@ -0,0 +1,275 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/util"
+)
+
+var (
+	// the characters which are not permitted in a label value
+	invalidLabelValueRegex = regexp.MustCompile("[^-A-Za-z0-9_.]+")
+)
+
+// NewMetadataMapper creates the mapper used to convert the machine metadata into node labels,
+// annotations and spec fields
+func NewMetadataMapper() (*MetadataMapper, error) {
+	var err error
+	mapper := &MetadataMapper{
+		lowercase: config.labelLowercase,
+	}
+	if mapper.prefix, err = parseKeyPrefix(config.labelPrefix); err != nil {
+		return nil, err
+	}
+	if mapper.annotationPrefix, err = parseKeyPrefix(config.annotationPrefix); err != nil {
+		return nil, err
+	}
+	if mapper.renames, err = parsePairs(config.labelRenames); err != nil {
+		return nil, fmt.Errorf("invalid label renames, %s", err)
+	}
+	if mapper.unschedulable, err = parsePairs(config.unschedulable); err != nil {
+		return nil, fmt.Errorf("invalid unschedulable metadata, %s", err)
+	}
+	if mapper.taints, err = parseTaintRules(config.taintRules); err != nil {
+		return nil, err
+	}
+	if config.labelInclude != "" {
+		mapper.include = strings.Split(config.labelInclude, ",")
+	}
+	if config.labelExclude != "" {
+		mapper.exclude = strings.Split(config.labelExclude, ",")
+	}
+	if config.annotationKeys != "" {
+		mapper.annotations = strings.Split(config.annotationKeys, ",")
+	}
+	// step: ensure the patterns are valid
+	for _, pattern := range append(append(mapper.include, mapper.exclude...), mapper.annotations...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid metadata pattern: %s, error: %s", pattern, err)
+		}
+	}
+
+	return mapper, nil
+}
+
+// parseKeyPrefix checks the prefix is a valid dns subdomain and ensures it ends with a slash
+func parseKeyPrefix(prefix string) (string, error) {
+	if prefix == "" {
+		return "", nil
+	}
+	if !util.IsDNS1123Subdomain(strings.TrimSuffix(prefix, "/")) {
+		return "", fmt.Errorf("invalid key prefix: %s, should be a dns subdomain", prefix)
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix = prefix + "/"
+	}
+
+	return prefix, nil
+}
+
+// Labels converts the metadata into a set of valid node labels; keys are filtered by the include and
+// exclude lists, renamed and prefixed, values are sanitized and anything still invalid is dropped
+func (r MetadataMapper) Labels(metadata map[string]string) map[string]string {
+	labels := make(map[string]string, 0)
+	for key, value := range metadata {
+		// step: is the key mapped to an annotation rather than a label?
+		if matchesAny(r.annotations, key) {
+			continue
+		}
+		// step: is the key permitted?
+		if !r.permitted(key) {
+			glog.V(5).Infof("Skipping the metadata: %s, not permitted by the label include / exclude lists", key)
+			continue
+		}
+		// step: rename and prefix the key
+		name := key
+		if renamed, found := r.renames[key]; found {
+			name = renamed
+		}
+		if r.prefix != "" && !strings.Contains(name, "/") {
+			name = r.prefix + name
+		}
+		if !util.IsQualifiedName(name) {
+			glog.Warningf("Dropping the metadata: %s, the label key: %s is invalid", key, name)
+			continue
+		}
+		// step: sanitize the value
+		label := sanitizeLabelValue(value, r.lowercase)
+		if !util.IsValidLabelValue(label) {
+			glog.Warningf("Dropping the metadata: %s, the label value: '%s' is invalid", key, value)
+			continue
+		}
+		labels[name] = label
+	}
+
+	return labels
+}
+
+// Annotations converts the metadata keys matching the annotation patterns into node annotations, the
+// values are left as is as annotations are not restricted like labels
+func (r MetadataMapper) Annotations(metadata map[string]string) map[string]string {
+	annotations := make(map[string]string, 0)
+	for key, value := range metadata {
+		if !matchesAny(r.annotations, key) {
+			continue
+		}
+		name := key
+		if r.annotationPrefix != "" && !strings.Contains(name, "/") {
+			name = r.annotationPrefix + name
+		}
+		if !util.IsQualifiedName(name) {
+			glog.Warningf("Dropping the metadata: %s, the annotation key: %s is invalid", key, name)
+			continue
+		}
+		annotations[name] = value
+	}
+
+	return annotations
+}
+
+// Unschedulable checks if the metadata matches the unschedulable rule, i.e. maintenance=true
+func (r MetadataMapper) Unschedulable(metadata map[string]string) bool {
+	if len(r.unschedulable) <= 0 {
+		return false
+	}
+	for key, value := range r.unschedulable {
+		if metadata[key] != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// HasTaints checks if any taint rules have been configured
+func (r MetadataMapper) HasTaints() bool {
+	return len(r.taints) > 0
+}
+
+// HasTaintEffect checks if any of the taint rules have the effect
+func (r MetadataMapper) HasTaintEffect(effect string) bool {
+	for _, rule := range r.taints {
+		if rule.effect == effect {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Taints converts the metadata into node taints using the taint rules
+func (r MetadataMapper) Taints(metadata map[string]string) []nodeTaint {
+	var taints []nodeTaint
+	for _, rule := range r.taints {
+		value, found := metadata[rule.key]
+		if !found || (rule.value != "*" && rule.value != value) {
+			continue
+		}
+		taints = append(taints, nodeTaint{Key: rule.key, Value: sanitizeLabelValue(value, false), Effect: rule.effect})
+	}
+
+	return taints
+}
+
+// permitted checks the key against the include and exclude lists
+func (r MetadataMapper) permitted(key string) bool {
+	if len(r.include) > 0 && !matchesAny(r.include, key) {
+		return false
+	}
+
+	return !matchesAny(r.exclude, key)
+}
+
+// matchesAny checks if the key matches any of the glob patterns
+func matchesAny(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(strings.TrimSpace(pattern), key); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sanitizeLabelValue converts the value into a valid label value, replacing any invalid characters,
+// trimming the ends to alphanumerics and truncating to the maximum length
+func sanitizeLabelValue(value string, lowercase bool) string {
+	if lowercase {
+		value = strings.ToLower(value)
+	}
+	value = invalidLabelValueRegex.ReplaceAllString(value, "-")
+	if len(value) > util.LabelValueMaxLength {
+		value = value[:util.LabelValueMaxLength]
+	}
+
+	return strings.Trim(value, "-_.")
+}
+
+// parseTaintRules parses a comma separated list of taint rules, i.e. spot=true:NoSchedule,gpu=*:NoExecute
+// where a value of * matches any value
+func parseTaintRules(value string) ([]taintRule, error) {
+	var rules []taintRule
+	if value == "" {
+		return rules, nil
+	}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		index := strings.LastIndex(item, ":")
+		if index < 0 {
+			return nil, fmt.Errorf("invalid taint rule: '%s', should be key=value:effect format", item)
+		}
+		pair, effect := item[:index], item[index+1:]
+		switch effect {
+		case taintNoSchedule, taintPreferNoSchedule, taintNoExecute:
+		default:
+			return nil, fmt.Errorf("invalid taint effect: '%s', should be NoSchedule, PreferNoSchedule or NoExecute", effect)
+		}
+		items := strings.SplitN(pair, "=", 2)
+		if len(items) != 2 || items[0] == "" || items[1] == "" {
+			return nil, fmt.Errorf("invalid taint rule: '%s', should be key=value:effect format", item)
+		}
+		if !util.IsQualifiedName(items[0]) {
+			return nil, fmt.Errorf("invalid taint rule: '%s', the key is not a valid taint key", item)
+		}
+		rules = append(rules, taintRule{key: items[0], value: items[1], effect: effect})
+	}
+
+	return rules, nil
+}
+
+// parsePairs parses a comma separated list of key=value pairs
+func parsePairs(value string) (map[string]string, error) {
+	pairs := make(map[string]string, 0)
+	if value == "" {
+		return pairs, nil
+	}
+	for _, pair := range strings.Split(value, ",") {
+		items := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(items) != 2 || items[0] == "" || items[1] == "" {
+			return nil, fmt.Errorf("invalid pair: '%s', should be key=value format", pair)
+		}
+		pairs[items[0]] = items[1]
+	}
+
+	return pairs, nil
+}
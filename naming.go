@@ -0,0 +1,116 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"k8s.io/kubernetes/pkg/util"
+)
+
+const (
+	// the length of a short machine id
+	shortIDLength = 8
+)
+
+var (
+	// the characters which are not permitted in a dns-1123 subdomain
+	invalidNameRegex = regexp.MustCompile("[^a-z0-9.-]+")
+	// the helpers available to the node name template
+	nodeNameFuncs = template.FuncMap{
+		"lower":      strings.ToLower,
+		"upper":      strings.ToUpper,
+		"replace":    func(old, new, value string) string { return strings.Replace(value, old, new, -1) },
+		"trimSuffix": func(suffix, value string) string { return strings.TrimSuffix(value, suffix) },
+		"dns1123":    sanitizeName,
+	}
+)
+
+// nodeNameContext ... the values available to the node name template
+type nodeNameContext struct {
+	// the ip address of the machine
+	IP string
+	// the machine id
+	ID string
+	// the short machine id
+	ShortID string
+	// the hostname resolved from dns
+	Hostname string
+	// the machine metadata
+	Metadata map[string]string
+}
+
+// parseNodeNameTemplate parses the node name template, i.e. {{ .Metadata.role }}-{{ .ShortID }}
+func parseNodeNameTemplate(content string) (*template.Template, error) {
+	tmpl, err := template.New("node-name").Funcs(nodeNameFuncs).Option("missingkey=error").Parse(content)
+	if err != nil {
+		return nil, fmt.Errorf("invalid node name template, error: %s", err)
+	}
+
+	return tmpl, nil
+}
+
+// renderNodeName renders the node name for the machine and ensures the result is a legal node name
+func renderNodeName(tmpl *template.Template, address string, machine *Machine) (string, error) {
+	shortID := machine.ID
+	if len(shortID) > shortIDLength {
+		shortID = shortID[:shortIDLength]
+	}
+	ctx := &nodeNameContext{
+		IP:       address,
+		ID:       machine.ID,
+		ShortID:  shortID,
+		Hostname: strings.TrimSuffix(machine.Hostname, "."),
+		Metadata: machine.Metadata,
+	}
+
+	content := new(bytes.Buffer)
+	if err := tmpl.Execute(content, ctx); err != nil {
+		return "", fmt.Errorf("unable to render the node name for machine: %s, error: %s", address, err)
+	}
+	name := strings.TrimSpace(content.String())
+
+	if err := validateNodeName(name); err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+// validateNodeName checks the name is a legal kubernetes node name
+func validateNodeName(name string) error {
+	if !util.IsDNS1123Subdomain(name) {
+		return fmt.Errorf("the node name: '%s' is not a valid dns-1123 subdomain", name)
+	}
+
+	return nil
+}
+
+// sanitizeName converts the value into a dns-1123 subdomain, lower casing and replacing any invalid characters
+func sanitizeName(value string) string {
+	name := invalidNameRegex.ReplaceAllString(strings.ToLower(value), "-")
+	name = strings.Trim(name, ".-")
+	if len(name) > util.DNS1123SubdomainMaxLength {
+		name = strings.Trim(name[:util.DNS1123SubdomainMaxLength], ".-")
+	}
+
+	return name
+}
@@ -0,0 +1,317 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+const (
+	// the annotation recording the annotations managed from the metadata
+	annotationManaged = "node-register/annotations"
+	// the annotation recording the labels managed from the metadata
+	annotationManagedLabels = "node-register/labels"
+	// the annotation recording we marked the node as unschedulable
+	annotationUnschedulable = "node-register/unschedulable"
+	// the annotation recording the taint keys managed from the metadata
+	annotationManagedTaints = "node-register/taints"
+	// the annotation the scheduler reads the node taints from, only honoured prior to kubernetes 1.6
+	annotationTaints = "scheduler.alpha.kubernetes.io/taints"
+)
+
+const (
+	// the taint effect which prevents new pods being scheduled
+	taintNoSchedule = "NoSchedule"
+	// the taint effect which avoids scheduling pods if possible
+	taintPreferNoSchedule = "PreferNoSchedule"
+	// the taint effect which evicts any pods not tolerating the taint, only honoured from kubernetes 1.6
+	taintNoExecute = "NoExecute"
+)
+
+// nodeTaints ... the taints in the spec of a node, which the vendored api predates; the taints are kept raw so
+// the fields of the taints we do not manage, i.e. the time a NoExecute taint was added, are preserved
+type nodeTaints struct {
+	// the resource version of the node the taints were read from
+	resourceVersion string
+	// the taints on the node
+	list []map[string]interface{}
+}
+
+// nodeTaint ... a taint placed on the node
+type nodeTaint struct {
+	// the key of the taint
+	Key string `json:"key"`
+	// the value of the taint
+	Value string `json:"value,omitempty"`
+	// the effect of the taint
+	Effect string `json:"effect"`
+}
+
+// taintRule ... a rule mapping the machine metadata to a taint
+type taintRule struct {
+	// the metadata key, which is also the taint key
+	key string
+	// the metadata value required, or * for any
+	value string
+	// the effect of the taint
+	effect string
+}
+
+// applyMachine applies the sources, identity, labels, annotations and spec fields derived from the machine to the node,
+// returning true if the node was changed. Only the labels and annotations we manage are removed and we only
+// mark a node schedulable if it was us who marked it unschedulable, i.e. a manual cordon is left alone. The taints
+// are applied to the spec taints given, or placed in the taints annotation when nil, i.e. prior to kubernetes 1.6
+func applyMachine(node *api.Node, machine *Machine, taints *nodeTaints) bool {
+	changed := false
+	if node.Annotations == nil {
+		node.Annotations = make(map[string]string, 0)
+	}
+
+	// step: record the sources which reported the machine; this alone is not a change, as a source which keeps failing
+	// and recovering would otherwise have us update every node each sync, it is written with any other change
+	sources := strings.Join(machine.Sources, ",")
+	if current, found := node.Annotations[annotationSources]; !found || current != sources {
+		glog.V(5).Infof("Setting the sources: %s on the node: %s", sources, node.Name)
+		node.Annotations[annotationSources] = sources
+	}
+	// step: back-fill the id and version of the machine, the id is how we detect an address being reused
+	if machine.ID != "" && node.Annotations[annotationMachineID] != machine.ID {
+		glog.V(4).Infof("Setting the machine id: %s on the node: %s", machine.ID, node.Name)
+		node.Annotations[annotationMachineID] = machine.ID
+		changed = true
+	}
+	if machine.Version != "" && node.Annotations[annotationVersion] != machine.Version {
+		glog.V(4).Infof("Setting the version: %s on the node: %s", machine.Version, node.Name)
+		node.Annotations[annotationVersion] = machine.Version
+		changed = true
+	}
+
+	// step: reconcile the labels and annotations derived from the metadata
+	if node.Labels == nil {
+		node.Labels = make(map[string]string, 0)
+	}
+	if applyManaged(node, "label", node.Labels, machine.Labels, annotationManagedLabels) {
+		changed = true
+	}
+	if applyManaged(node, "annotation", node.Annotations, machine.Annotations, annotationManaged) {
+		changed = true
+	}
+
+	// step: mark the node as unschedulable
+	switch {
+	case machine.Unschedulable && !node.Spec.Unschedulable:
+		glog.V(3).Infof("Marking the node: %s as unschedulable", node.Name)
+		node.Spec.Unschedulable = true
+		node.Annotations[annotationUnschedulable] = "true"
+		changed = true
+	case !machine.Unschedulable && node.Spec.Unschedulable && node.Annotations[annotationUnschedulable] != "":
+		glog.V(3).Infof("Marking the node: %s as schedulable", node.Name)
+		node.Spec.Unschedulable = false
+		delete(node.Annotations, annotationUnschedulable)
+		changed = true
+	}
+
+	// step: reconcile the taints
+	if applyTaints(node, taints, machine.Taints) {
+		changed = true
+	}
+
+	return changed
+}
+
+// applyManaged reconciles the values we manage with those wanted, recording the keys we manage in an annotation
+// so only the keys we placed are ever removed, returning true if the node was changed
+func applyManaged(node *api.Node, kind string, values, wanted map[string]string, annotation string) bool {
+	changed := false
+	// step: remove any managed keys no longer wanted
+	for _, key := range strings.Split(node.Annotations[annotation], ",") {
+		if _, found := wanted[key]; key != "" && !found {
+			glog.V(4).Infof("Removing the %s: %s from the node: %s", kind, key, node.Name)
+			delete(values, key)
+			changed = true
+		}
+	}
+	// step: add or update the values
+	var managed []string
+	for key, value := range wanted {
+		managed = append(managed, key)
+		if current, found := values[key]; !found || current != value {
+			glog.V(4).Infof("Setting the %s: %s=%s on the node: %s", kind, key, value, node.Name)
+			values[key] = value
+			changed = true
+		}
+	}
+	sort.Strings(managed)
+	if strings.Join(managed, ",") != node.Annotations[annotation] {
+		node.Annotations[annotation] = strings.Join(managed, ",")
+		if len(managed) <= 0 {
+			delete(node.Annotations, annotation)
+		}
+		changed = true
+	}
+
+	return changed
+}
+
+// taintsInSpec checks if the cluster reads the taints from the node spec, kubernetes stopped honouring the
+// taints annotation in 1.6; if we are unable to determine the version we assume a current cluster
+func taintsInSpec(ctx context.Context, cluster *ClusterTarget) bool {
+	major, minor, err := cluster.kapi.ServerVersion(ctx)
+	if err != nil {
+		if metadataMapper.HasTaints() {
+			glog.Warningf("Unable to determine the kubernetes version of cluster: %s, placing the taints in the node spec, error: %s", cluster.Name, err)
+		}
+		return true
+	}
+	if major > 1 || (major == 1 && minor >= 6) {
+		return true
+	}
+	glog.V(3).Infof("Cluster: %s is running kubernetes %d.%d, placing the taints in the %s annotation", cluster.Name, major, minor, annotationTaints)
+	if metadataMapper.HasTaintEffect(taintNoExecute) {
+		glog.Warningf("Cluster: %s is running kubernetes %d.%d, which does not honour the %s taints", cluster.Name, major, minor, taintNoExecute)
+	}
+
+	return false
+}
+
+// applyTaints reconciles the taints we manage on the node, returning true if the node was changed. The taints are
+// reconciled in the spec taints given, or the taints annotation when nil. Any taints added by other controllers,
+// i.e. keys we did not place, are left untouched
+func applyTaints(node *api.Node, spec *nodeTaints, taints []nodeTaint) bool {
+	var current []map[string]interface{}
+	switch {
+	case spec != nil:
+		current = spec.list
+	case node.Annotations[annotationTaints] != "":
+		if err := json.Unmarshal([]byte(node.Annotations[annotationTaints]), &current); err != nil {
+			glog.Errorf("Unable to decode the taints on the node: %s, error: %s", node.Name, err)
+			return false
+		}
+	}
+	managed := strings.Split(node.Annotations[annotationManagedTaints], ",")
+
+	// step: keep the taints which are not ours
+	var list []map[string]interface{}
+	var placed []nodeTaint
+	for _, taint := range current {
+		if x := decodeTaint(taint); containsString(managed, x.Key) {
+			placed = append(placed, x)
+			continue
+		}
+		list = append(list, taint)
+	}
+	// step: add the taints derived from the metadata, reusing any already on the node
+	var keys []string
+	for _, taint := range taints {
+		keys = append(keys, taint.Key)
+		list = append(list, encodeTaint(current, taint))
+	}
+	sort.Strings(keys)
+
+	changed := false
+	if !taintsEqual(placed, taints) {
+		glog.V(3).Infof("Updating the taints on the node: %s, taints: %v", node.Name, taints)
+		switch {
+		case spec != nil:
+			spec.list = list
+		case len(list) > 0:
+			content, err := json.Marshal(list)
+			if err != nil {
+				glog.Errorf("Unable to encode the taints for the node: %s, error: %s", node.Name, err)
+				return false
+			}
+			node.Annotations[annotationTaints] = string(content)
+		default:
+			delete(node.Annotations, annotationTaints)
+		}
+		changed = true
+	}
+	if strings.Join(keys, ",") != node.Annotations[annotationManagedTaints] {
+		node.Annotations[annotationManagedTaints] = strings.Join(keys, ",")
+		if len(keys) <= 0 {
+			delete(node.Annotations, annotationManagedTaints)
+		}
+		changed = true
+	}
+
+	return changed
+}
+
+// decodeTaint extracts the key, value and effect from a raw taint
+func decodeTaint(taint map[string]interface{}) nodeTaint {
+	key, _ := taint["key"].(string)
+	value, _ := taint["value"].(string)
+	effect, _ := taint["effect"].(string)
+
+	return nodeTaint{Key: key, Value: value, Effect: effect}
+}
+
+// encodeTaint returns the raw taint, reusing the taint from the list if it's already present
+func encodeTaint(list []map[string]interface{}, taint nodeTaint) map[string]interface{} {
+	for _, x := range list {
+		if decodeTaint(x) == taint {
+			return x
+		}
+	}
+	encoded := map[string]interface{}{"key": taint.Key, "effect": taint.Effect}
+	if taint.Value != "" {
+		encoded["value"] = taint.Value
+	}
+
+	return encoded
+}
+
+// encodeTaints encodes the taints as json, i.e. for the flattened node fields
+func encodeTaints(list []map[string]interface{}) string {
+	if list == nil {
+		list = []map[string]interface{}{}
+	}
+	content, err := json.Marshal(list)
+	if err != nil {
+		glog.Errorf("Unable to encode the taints, error: %s", err)
+		return ""
+	}
+
+	return string(content)
+}
+
+// taintsEqual checks if the two lists contain the same taints, regardless of order
+func taintsEqual(a, b []nodeTaint) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, x := range a {
+		found := false
+		for _, y := range b {
+			if x == y {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
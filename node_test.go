@@ -0,0 +1,122 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/api"
+)
+
+func TestApplyTaintsSpec(t *testing.T) {
+	node := &api.Node{
+		ObjectMeta: api.ObjectMeta{
+			Name:        "10.0.0.1",
+			Annotations: map[string]string{annotationManagedTaints: "gpu,spot"},
+		},
+	}
+	taints := &nodeTaints{
+		resourceVersion: "10",
+		list: []map[string]interface{}{
+			{"key": "node.kubernetes.io/unreachable", "effect": "NoExecute", "timeAdded": "2017-01-01T00:00:00Z"},
+			{"key": "spot", "value": "true", "effect": "NoExecute", "timeAdded": "2017-01-01T00:00:00Z"},
+			{"key": "gpu", "value": "nvidia", "effect": "NoSchedule"},
+		},
+	}
+
+	// step: the taints are unchanged
+	wanted := []nodeTaint{{Key: "gpu", Value: "nvidia", Effect: "NoSchedule"}, {Key: "spot", Value: "true", Effect: "NoExecute"}}
+	if applyTaints(node, taints, wanted) {
+		t.Errorf("expected the taints to be unchanged, got: %v", taints.list)
+	}
+
+	// step: the gpu taint is removed, the spot taint kept as it was and the other taints left alone
+	wanted = []nodeTaint{{Key: "spot", Value: "true", Effect: "NoExecute"}, {Key: "dedicated", Value: "ci", Effect: "PreferNoSchedule"}}
+	if !applyTaints(node, taints, wanted) {
+		t.Fatalf("expected the taints to be changed")
+	}
+	expected := []map[string]interface{}{
+		{"key": "node.kubernetes.io/unreachable", "effect": "NoExecute", "timeAdded": "2017-01-01T00:00:00Z"},
+		{"key": "spot", "value": "true", "effect": "NoExecute", "timeAdded": "2017-01-01T00:00:00Z"},
+		{"key": "dedicated", "value": "ci", "effect": "PreferNoSchedule"},
+	}
+	if !reflect.DeepEqual(taints.list, expected) {
+		t.Errorf("expected the taints: %v, got: %v", expected, taints.list)
+	}
+	if managed := node.Annotations[annotationManagedTaints]; managed != "dedicated,spot" {
+		t.Errorf("expected the managed taints: dedicated,spot, got: %s", managed)
+	}
+	if _, found := node.Annotations[annotationTaints]; found {
+		t.Errorf("expected no taints annotation when the taints are in the spec")
+	}
+
+	// step: removing all our taints leaves the others
+	if !applyTaints(node, taints, nil) {
+		t.Fatalf("expected the taints to be changed")
+	}
+	if len(taints.list) != 1 || taints.list[0]["key"] != "node.kubernetes.io/unreachable" {
+		t.Errorf("expected only the taint we did not place, got: %v", taints.list)
+	}
+	if _, found := node.Annotations[annotationManagedTaints]; found {
+		t.Errorf("expected the managed taints annotation to be removed")
+	}
+}
+
+func TestApplyTaintsAnnotation(t *testing.T) {
+	node := &api.Node{
+		ObjectMeta: api.ObjectMeta{
+			Name:        "10.0.0.1",
+			Annotations: map[string]string{annotationTaints: `[{"key":"dedicated","value":"db","effect":"NoSchedule"}]`},
+		},
+	}
+	if !applyTaints(node, nil, []nodeTaint{{Key: "spot", Value: "true", Effect: "NoSchedule"}}) {
+		t.Fatalf("expected the taints to be changed")
+	}
+	var list []nodeTaint
+	if err := json.Unmarshal([]byte(node.Annotations[annotationTaints]), &list); err != nil {
+		t.Fatalf("unable to decode the taints annotation, error: %s", err)
+	}
+	expected := []nodeTaint{{Key: "dedicated", Value: "db", Effect: "NoSchedule"}, {Key: "spot", Value: "true", Effect: "NoSchedule"}}
+	if !reflect.DeepEqual(list, expected) {
+		t.Errorf("expected the taints: %v, got: %v", expected, list)
+	}
+	if applyTaints(node, nil, []nodeTaint{{Key: "spot", Value: "true", Effect: "NoSchedule"}}) {
+		t.Errorf("expected the taints to be unchanged")
+	}
+}
+
+func TestParseTaintRules(t *testing.T) {
+	rules, err := parseTaintRules("spot=true:NoSchedule, gpu=*:PreferNoSchedule,dedicated=db:NoExecute")
+	if err != nil {
+		t.Fatalf("unable to parse the taint rules, error: %s", err)
+	}
+	expected := []taintRule{
+		{key: "spot", value: "true", effect: taintNoSchedule},
+		{key: "gpu", value: "*", effect: taintPreferNoSchedule},
+		{key: "dedicated", value: "db", effect: taintNoExecute},
+	}
+	if !reflect.DeepEqual(rules, expected) {
+		t.Errorf("expected the rules: %v, got: %v", expected, rules)
+	}
+	for _, invalid := range []string{"spot=true", "spot:NoSchedule", "spot=true:Evict", "=true:NoSchedule", "bad key=true:NoSchedule"} {
+		if _, err := parseTaintRules(invalid); err == nil {
+			t.Errorf("expected the rule: %s to be invalid", invalid)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+
+	"k8s.io/kubernetes/pkg/api"
+)
+
+const (
+	// the node is registered
+	actionRegister = "register"
+	// the labels, annotations or spec of the node are updated
+	actionRelabel = "relabel"
+	// the node is deleted and registered again
+	actionRecreate = "delete-and-recreate"
+	// the node is reaped
+	actionReap = "reap"
+	// the node is deregistered by an operator
+	actionDeregister = "deregister"
+	// the machine is skipped
+	actionSkip = "skip"
+)
+
+// plannedAction ... an action taken, or intended, against a node
+type plannedAction struct {
+	// the action
+	Action string `json:"action"`
+	// the cluster the node is in
+	Cluster string `json:"cluster"`
+	// the name of the node
+	Node string `json:"node,omitempty"`
+	// the ip address of the machine
+	Address string `json:"address,omitempty"`
+	// the id of the machine
+	MachineID string `json:"machine_id,omitempty"`
+	// the reason for the action
+	Reason string `json:"reason,omitempty"`
+	// the fields changed on the node
+	Changes map[string]*fieldChange `json:"changes,omitempty"`
+}
+
+// fieldChange ... the change of a field on the node
+type fieldChange struct {
+	// the value before the change
+	Before *string `json:"before"`
+	// the value after the change
+	After *string `json:"after"`
+}
+
+// Reset clears the actions ready for the next sync
+func (r *ActionPlan) Reset() {
+	r.Lock()
+	defer r.Unlock()
+	r.actions = nil
+}
+
+// Record adds the action to the plan
+func (r *ActionPlan) Record(action *plannedAction) {
+	r.Lock()
+	defer r.Unlock()
+	r.actions = append(r.actions, action)
+}
+
+// recordAction adds the action to the plan and, unless it's a dry run, notifies the webhooks of the change
+func recordAction(action *plannedAction) {
+	syncPlan.Record(action)
+	if action.Action == actionSkip {
+		return
+	}
+	if config.journald {
+		journalAction(action)
+	}
+	if !config.dryRun {
+		notifyWebhooks(action)
+	}
+}
+
+// Actions returns the actions in the plan
+func (r *ActionPlan) Actions() []*plannedAction {
+	r.Lock()
+	defer r.Unlock()
+	return append([]*plannedAction{}, r.actions...)
+}
+
+// Write writes the plan as either a table or json
+func (r *ActionPlan) Write(w io.Writer, format string) error {
+	actions := r.Actions()
+	switch format {
+	case "json":
+		return writeIndented(w, actions)
+	case "table":
+		writer := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(writer, "ACTION\tCLUSTER\tNODE\tADDRESS\tREASON\tCHANGES")
+		for _, x := range actions {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%d\n", x.Action, x.Cluster, x.Node, x.Address, x.Reason, len(x.Changes))
+		}
+		return writer.Flush()
+	default:
+		return fmt.Errorf("unsupported output format: %s", format)
+	}
+}
+
+// nodeFields flattens the labels, annotations and spec fields we manage on the node, including the spec taints if given
+func nodeFields(node *api.Node, taints *nodeTaints) map[string]string {
+	fields := make(map[string]string, 0)
+	if node == nil {
+		return fields
+	}
+	if taints != nil {
+		fields["spec/taints"] = encodeTaints(taints.list)
+	}
+	for key, value := range node.Labels {
+		fields["labels/"+key] = value
+	}
+	for key, value := range node.Annotations {
+		fields["annotations/"+key] = value
+	}
+	fields["spec/unschedulable"] = strconv.FormatBool(node.Spec.Unschedulable)
+
+	return fields
+}
+
+// diffFields returns the fields which differ between the two flattened nodes
+func diffFields(before, after map[string]string) map[string]*fieldChange {
+	keys := make(map[string]bool, 0)
+	for key := range before {
+		keys[key] = true
+	}
+	for key := range after {
+		keys[key] = true
+	}
+
+	changes := make(map[string]*fieldChange, 0)
+	for key := range keys {
+		change := new(fieldChange)
+		if value, found := before[key]; found {
+			change.Before = &value
+		}
+		if value, found := after[key]; found {
+			change.After = &value
+		}
+		if change.Before != nil && change.After != nil && *change.Before == *change.After {
+			continue
+		}
+		changes[key] = change
+	}
+
+	return changes
+}
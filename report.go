@@ -0,0 +1,120 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// every machine was handled without error
+	exitOK = 0
+	// some of the machines or nodes could not be handled
+	exitPartialFailure = 2
+	// the machine source or the kubernetes api could not be reached
+	exitUnreachable = 3
+)
+
+// onceReport ... the report written after a single reconcile pass
+type onceReport struct {
+	// the time the pass started
+	Started time.Time `json:"started"`
+	// the time the pass finished
+	Finished time.Time `json:"finished"`
+	// the duration of the pass
+	Duration string `json:"duration"`
+	// the outcome of the pass, ok, partial-failure or unreachable
+	Status string `json:"status"`
+	// the exit code of the process
+	ExitCode int `json:"exit_code"`
+	// the machines considered and the decision taken
+	Machines []*machineStatus `json:"machines"`
+	// the actions taken against the nodes
+	Actions []*plannedAction `json:"actions"`
+	// the errors encountered
+	Errors []string `json:"errors"`
+}
+
+// runOnce performs a single reconcile pass, writes the report and returns the exit code
+func runOnce(ctx context.Context, source MachineSource) int {
+	report := &onceReport{
+		Started:  time.Now(),
+		Machines: []*machineStatus{},
+		Actions:  []*plannedAction{},
+		Errors:   []string{},
+	}
+
+	// step: ensure we can reach the kubernetes api of each cluster
+	reachable := true
+	for _, cluster := range clusters {
+		if _, err := cluster.kapi.GetNodes(ctx); err != nil {
+			glog.Errorf("Unable to reach the kubernetes api, cluster: %s, error: %s", cluster.Name, err)
+			report.Errors = append(report.Errors, fmt.Sprintf("cluster: %s, error: %s", cluster.Name, err))
+			reachable = false
+		}
+	}
+
+	// step: perform the pass
+	if reachable {
+		syncMachines(ctx, source)
+		reachable = syncStatus.Ready()
+		report.Machines = append(report.Machines, syncStatus.Machines()...)
+		report.Actions = append(report.Actions, syncPlan.Actions()...)
+		report.Errors = append(report.Errors, syncStatus.Errors()...)
+	}
+
+	switch {
+	case !reachable:
+		report.Status, report.ExitCode = "unreachable", exitUnreachable
+	case len(report.Errors) > 0:
+		report.Status, report.ExitCode = "partial-failure", exitPartialFailure
+	default:
+		report.Status, report.ExitCode = "ok", exitOK
+	}
+	report.Finished = time.Now()
+	report.Duration = report.Finished.Sub(report.Started).String()
+
+	if err := writeReport(report, config.reportFile); err != nil {
+		glog.Errorf("Failed to write the report, error: %s", err)
+		if report.ExitCode == exitOK {
+			return exitPartialFailure
+		}
+	}
+
+	return report.ExitCode
+}
+
+// writeReport writes the report to the file, or stdout if the filename is -
+func writeReport(report *onceReport, filename string) error {
+	var w io.Writer = os.Stdout
+	if filename != "-" {
+		file, err := os.Create(filename)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	return writeIndented(w, report)
+}
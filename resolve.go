@@ -0,0 +1,176 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// cachedHostname ... a hostname in the resolution cache
+type cachedHostname struct {
+	// the resolved hostname
+	hostname string
+	// the failure to resolve the address, if it failed
+	err error
+	// the time the entry expires
+	expires time.Time
+}
+
+// NewHostResolver creates a resolver used to convert the machine addresses into hostnames
+func NewHostResolver() *HostResolver {
+	return &HostResolver{
+		resolver: newResolver(config.dnsServer, config.dnsTimeout),
+		timeout:  config.dnsTimeout,
+		cacheTTL: config.dnsCacheTTL,
+		cache:    make(map[string]*cachedHostname, 0),
+	}
+}
+
+// Resolve converts the ip address into a hostname, using the cache if possible. Failures are cached as well,
+// so an address without a ptr record is not looked up again until the entry expires
+func (r *HostResolver) Resolve(ctx context.Context, address string) (string, error) {
+	// step: check the cache for the address
+	if entry, found := r.cached(address); found {
+		glog.V(5).Infof("Using the cached resolution of the address: %s, hostname: '%s'", address, entry.hostname)
+		return entry.hostname, entry.err
+	}
+
+	hostname, err := r.lookup(ctx, address)
+	// step: add the result to the cache, unless we were interrupted
+	if r.cacheTTL > 0 && ctx.Err() == nil {
+		r.Lock()
+		r.cache[address] = &cachedHostname{hostname: hostname, err: err, expires: time.Now().Add(r.cacheTTL)}
+		r.Unlock()
+	}
+
+	return hostname, err
+}
+
+// lookup performs the reverse lookup of the address, selecting one of the hostnames
+func (r *HostResolver) lookup(ctx context.Context, address string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	// step: perform the reverse lookup
+	names, err := r.resolver.LookupAddr(ctx, address)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve the ip address: %s, error: %s", address, err)
+	}
+	var hostnames []string
+	for _, name := range names {
+		hostnames = append(hostnames, strings.TrimSuffix(name, "."))
+	}
+
+	// step: are we confirming the hostnames resolve back to the address?
+	if config.dnsConfirm {
+		hostnames = r.confirmed(ctx, address, hostnames)
+	}
+	if len(hostnames) <= 0 {
+		return "", fmt.Errorf("no valid ptr records found for the ip address: %s", address)
+	}
+
+	hostname := selectHostname(hostnames, config.dnsSelect)
+	glog.V(4).Infof("Resolved the address: %s to the hostname: %s", address, hostname)
+
+	return hostname, nil
+}
+
+// cached retrieves the resolution of the address from the cache if it's not expired
+func (r *HostResolver) cached(address string) (*cachedHostname, bool) {
+	r.Lock()
+	defer r.Unlock()
+	entry, found := r.cache[address]
+	if !found {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(r.cache, address)
+		return nil, false
+	}
+
+	return entry, true
+}
+
+// confirmed filters the hostnames to those whose forward lookup contains the address
+func (r *HostResolver) confirmed(ctx context.Context, address string, hostnames []string) []string {
+	var list []string
+	for _, hostname := range hostnames {
+		addresses, err := r.resolver.LookupHost(ctx, hostname)
+		if err != nil {
+			glog.V(4).Infof("Unable to forward confirm the hostname: %s, error: %s", hostname, err)
+			continue
+		}
+		if !containsString(addresses, address) {
+			glog.V(4).Infof("The hostname: %s does not resolve back to the address: %s", hostname, address)
+			continue
+		}
+		list = append(list, hostname)
+	}
+
+	return list
+}
+
+// selectHostname chooses which of the ptr records to use, either the first, shortest, longest or sorted
+func selectHostname(hostnames []string, method string) string {
+	selected := hostnames[0]
+	switch method {
+	case "shortest":
+		for _, x := range hostnames {
+			if len(x) < len(selected) {
+				selected = x
+			}
+		}
+	case "longest":
+		for _, x := range hostnames {
+			if len(x) > len(selected) {
+				selected = x
+			}
+		}
+	case "sorted":
+		sorted := append([]string{}, hostnames...)
+		sort.Strings(sorted)
+		selected = sorted[0]
+	}
+
+	return selected
+}
+
+// resolveHostname resolves the machine address to a hostname, applying the fallback policy on
+// failure; the returned bool indicates the machine should be skipped
+func resolveHostname(ctx context.Context, address string) (string, bool, error) {
+	hostname, err := hostResolver.Resolve(ctx, address)
+	if err == nil {
+		return hostname, false, nil
+	}
+
+	switch config.dnsFallback {
+	case "ip":
+		glog.Warningf("Using the ip address for the node name, %s", err)
+		return address, false, nil
+	case "skip":
+		glog.Warningf("Skipping the machine: %s, %s", address, err)
+		return "", true, nil
+	default:
+		return "", false, err
+	}
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHostResolverCache(t *testing.T) {
+	server := newTestDNSServer(t, map[string][]string{"worker-1.example.com.": {"10.0.0.1"}}, nil)
+	server.ptr = map[string][]string{"1.0.0.10.in-addr.arpa.": {"worker-1.example.com."}}
+	defer server.Close()
+
+	resolver := &HostResolver{
+		resolver: newResolver(server.conn.LocalAddr().String(), time.Second),
+		timeout:  time.Duration(2) * time.Second,
+		cacheTTL: time.Minute,
+		cache:    make(map[string]*cachedHostname, 0),
+	}
+	hostname, err := resolver.Resolve(context.Background(), "10.0.0.1")
+	if err != nil {
+		t.Fatalf("unable to resolve the address, error: %s", err)
+	}
+	if hostname != "worker-1.example.com" {
+		t.Errorf("expected the hostname: worker-1.example.com, got: %s", hostname)
+	}
+
+	// step: an address without a ptr record fails, and the failure is cached
+	if _, err := resolver.Resolve(context.Background(), "10.0.0.2"); err == nil {
+		t.Fatalf("expected an error for an address without a ptr record")
+	}
+	queries := atomic.LoadInt32(&server.queries)
+	if hostname, err := resolver.Resolve(context.Background(), "10.0.0.1"); err != nil || hostname != "worker-1.example.com" {
+		t.Errorf("expected the cached hostname, got: %s, error: %v", hostname, err)
+	}
+	if _, err := resolver.Resolve(context.Background(), "10.0.0.2"); err == nil {
+		t.Errorf("expected the cached failure")
+	}
+	if current := atomic.LoadInt32(&server.queries); current != queries {
+		t.Errorf("expected the cached results to be used, %d queries were made", current-queries)
+	}
+
+	// step: a cancelled lookup is neither performed nor cached
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := resolver.Resolve(ctx, "10.0.0.3"); err == nil {
+		t.Errorf("expected an error when the context is cancelled")
+	}
+	if _, found := resolver.cached("10.0.0.3"); found {
+		t.Errorf("expected the cancelled lookup not to be cached")
+	}
+
+	// step: the entries expire
+	resolver.cache["10.0.0.2"].expires = time.Now().Add(-time.Second)
+	if _, found := resolver.cached("10.0.0.2"); found {
+		t.Errorf("expected the entry to have expired")
+	}
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+// serviceStatus ... the response of the /status endpoint
+type serviceStatus struct {
+	// the version of the service
+	Version string `json:"version"`
+	// the time the service was started
+	Started time.Time `json:"started"`
+	// the time the last sync finished
+	LastSync *time.Time `json:"last_sync,omitempty"`
+	// the duration of the last sync
+	LastDuration string `json:"last_sync_duration,omitempty"`
+	// the number of syncs performed
+	Syncs int `json:"syncs"`
+	// the errors encountered in the last sync
+	Errors []string `json:"errors"`
+	// whether the service is ready
+	Ready bool `json:"ready"`
+	// whether leader election is enabled, there is no election so this is always false and every instance reconciles
+	LeaderElection bool `json:"leader_election"`
+	// whether the changes are only being logged
+	DryRun bool `json:"dry_run"`
+	// the machine sources in use
+	Sources []string `json:"sources"`
+	// the clusters being registered into
+	Clusters []string `json:"clusters"`
+}
+
+// nodeStatus ... a node in the response of the /nodes endpoint
+type nodeStatus struct {
+	// the cluster the node is in
+	Cluster string `json:"cluster"`
+	// the name of the node
+	Name string `json:"name"`
+	// whether the node was registered by us
+	Managed bool `json:"managed"`
+	// the id of the machine the node was registered from
+	MachineID string `json:"machine_id,omitempty"`
+	// the sources which reported the machine
+	Sources string `json:"sources,omitempty"`
+	// the status of the ready condition
+	Ready string `json:"ready"`
+	// the last heartbeat of the node
+	Heartbeat *time.Time `json:"heartbeat,omitempty"`
+	// the time since the last heartbeat
+	HeartbeatAge string `json:"heartbeat_age,omitempty"`
+	// the recent health checks of the node
+	Health []healthCheck `json:"health"`
+}
+
+// serveStatus starts the http server exposing the status of the service
+func serveStatus(address string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
+		writeProbe(w, syncStatus.Healthy())
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, req *http.Request) {
+		writeProbe(w, syncStatus.Ready())
+	})
+	mux.HandleFunc("/status", statusHandler)
+	mux.HandleFunc("/machines", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, syncStatus.Machines())
+	})
+	mux.HandleFunc("/nodes", nodesHandler)
+	mux.HandleFunc("/cycles", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, cycleTracer.History())
+	})
+
+	glog.Infof("Starting the status api on: %s", address)
+	go func() {
+		if err := http.ListenAndServe(address, mux); err != nil {
+			glog.Fatalf("Failed to start the status api on: %s, error: %s", address, err)
+		}
+	}()
+}
+
+// statusHandler returns the state of the sync loop
+func statusHandler(w http.ResponseWriter, req *http.Request) {
+	syncStatus.RLock()
+	status := &serviceStatus{
+		Version:  Version,
+		Started:  syncStatus.started,
+		Syncs:    syncStatus.syncs,
+		Errors:   append([]string{}, syncStatus.lastErrors...),
+		DryRun:   config.dryRun,
+		Sources:  config.machineSources,
+		Clusters: []string{},
+	}
+	if !syncStatus.lastSync.IsZero() {
+		last := syncStatus.lastSync
+		status.LastSync = &last
+		status.LastDuration = syncStatus.lastDuration.String()
+	}
+	syncStatus.RUnlock()
+	status.Ready = syncStatus.Ready()
+	for _, cluster := range clusters {
+		status.Clusters = append(status.Clusters, cluster.Name)
+	}
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+// nodesHandler returns the nodes in each of the clusters
+func nodesHandler(w http.ResponseWriter, req *http.Request) {
+	list := []*nodeStatus{}
+	for _, cluster := range clusters {
+		nodes, err := cluster.kapi.GetNodes(req.Context())
+		if err != nil {
+			glog.Errorf("Unable to retrieve the nodes from cluster: %s, error: %s", cluster.Name, err)
+			writeJSON(w, http.StatusBadGateway, map[string]string{"cluster": cluster.Name, "error": err.Error()})
+			return
+		}
+		for _, node := range nodes {
+			status := &nodeStatus{
+				Cluster:   cluster.Name,
+				Name:      node.Name,
+				MachineID: node.Annotations[annotationMachineID],
+				Sources:   node.Annotations[annotationSources],
+				Ready:     string(api.ConditionUnknown),
+				Health:    syncStatus.Health(node.Name),
+			}
+			_, status.Managed = node.Annotations[annotationSources]
+			for _, condition := range node.Status.Conditions {
+				if condition.Type == api.NodeReady {
+					heartbeat := condition.LastHeartbeatTime.Time
+					status.Ready = string(condition.Status)
+					status.Heartbeat = &heartbeat
+					status.HeartbeatAge = time.Since(heartbeat).String()
+				}
+			}
+			list = append(list, status)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+// writeProbe writes the response for a health or readiness probe
+func writeProbe(w http.ResponseWriter, ok bool) {
+	if !ok {
+		http.Error(w, "not ok", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+// writeJSON encodes the value as the json response
+func writeJSON(w http.ResponseWriter, code int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		glog.Errorf("Unable to encode the response, error: %s", err)
+	}
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// the time past the shutdown deadline we wait for the work bound by it to return before forcing an exit
+	shutdownGrace = time.Duration(5) * time.Second
+)
+
+// shutdownDeadline is the single deadline all the work during a shutdown is bound by
+var shutdownDeadline = newShutdownTimer()
+
+// shutdownTimer ... the deadline for the shutdown, started by the first signal or on exiting
+type shutdownTimer struct {
+	once sync.Once
+	// the time the shutdown must be completed by
+	deadline time.Time
+	// the context for work we complete rather than interrupt, cancelled at the deadline
+	ctx context.Context
+	// cancels the context
+	cancel context.CancelFunc
+}
+
+// newShutdownTimer creates a shutdown timer, the deadline is not set until it's started
+func newShutdownTimer() *shutdownTimer {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &shutdownTimer{ctx: ctx, cancel: cancel}
+}
+
+// Start sets the deadline from now, returning the deadline; only the first call sets it
+func (r *shutdownTimer) Start(timeout time.Duration) time.Time {
+	r.once.Do(func() {
+		r.deadline = time.Now().Add(timeout)
+		time.AfterFunc(timeout, r.cancel)
+	})
+
+	return r.deadline
+}
+
+// Context returns a context for work we must complete rather than interrupt, i.e. recreating a node we have
+// deleted; a shutdown does not cancel it, but it cannot outlive the shutdown deadline or the timeout
+func (r *shutdownTimer) Context(timeout time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.ctx, timeout)
+}
+
+// contextTransport ... binds the requests to a context, so they are cancelled when the context is done
+type contextTransport struct {
+	// the context the requests are bound to
+	ctx context.Context
+	// the underlying transport
+	next http.RoundTripper
+}
+
+// RoundTrip performs the request under the context
+func (r *contextTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	next := r.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return next.RoundTrip(request.WithContext(r.ctx))
+}
+
+// handleSignals cancels the context on a shutdown signal, forcing an exit if we have not shutdown shortly after the
+// shutdown deadline, giving any work bound by the deadline the chance to return
+func handleSignals(cancel context.CancelFunc) {
+	signalChannel := make(chan os.Signal, 1)
+	signal.Notify(signalChannel, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+
+	go func() {
+		received := <-signalChannel
+		deadline := shutdownDeadline.Start(config.shutdownTimeout)
+		glog.Infof("Received the signal: %s, shutting down, timeout: %s", received, config.shutdownTimeout)
+		if err := sdNotify("STOPPING=1"); err != nil {
+			glog.Errorf("Failed to notify systemd, %s", err)
+		}
+		// step: cancel any in-flight requests
+		cancel()
+
+		select {
+		case received = <-signalChannel:
+			glog.Warningf("Received a second signal: %s, exiting immediately", received)
+		case <-time.After(deadline.Add(shutdownGrace).Sub(time.Now())):
+			glog.Errorf("Failed to shutdown within %s, exiting", config.shutdownTimeout)
+		}
+		glog.Flush()
+		os.Exit(1)
+	}()
+}
+
+// shutdown flushes the audit log, webhooks, traces and logs prior to exiting, within the shutdown deadline
+func shutdown() {
+	deadline := shutdownDeadline.Start(config.shutdownTimeout)
+	if auditLog != nil {
+		if err := auditLog.Close(); err != nil {
+			glog.Errorf("Failed to close the audit log, error: %s", err)
+		}
+	}
+	flushWebhooks(deadline.Sub(time.Now()))
+	cycleTracer.Flush()
+	glog.Flush()
+}
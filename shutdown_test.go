@@ -0,0 +1,55 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestShutdownTimer(t *testing.T) {
+	timer := newShutdownTimer()
+	ctx, cancel := timer.Context(time.Minute)
+	defer cancel()
+	select {
+	case <-ctx.Done():
+		t.Fatalf("expected the context not to be done before the shutdown")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// step: the first start sets the deadline, the later ones share it
+	deadline := timer.Start(100 * time.Millisecond)
+	if later := timer.Start(time.Minute); !later.Equal(deadline) {
+		t.Errorf("expected the deadline: %s, got: %s", deadline, later)
+	}
+	// step: the work started before and after the shutdown is bound by the one deadline
+	after, cancelAfter := timer.Context(time.Minute)
+	defer cancelAfter()
+	for _, x := range []struct {
+		name string
+		done <-chan struct{}
+	}{{"before", ctx.Done()}, {"after", after.Done()}} {
+		select {
+		case <-x.done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected the context started %s the shutdown to be done at the deadline", x.name)
+		}
+	}
+	if time.Now().Before(deadline) {
+		t.Errorf("expected the contexts to be done no earlier than the deadline")
+	}
+}
@@ -0,0 +1,224 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// NewMachineSource creates the machine source we are pulling the machines from, when multiple sources
+// are given the machines are merged, the order of the sources being the precedence for metadata conflicts
+func NewMachineSource(names []string) (MachineSource, error) {
+	service := new(MultiSource)
+	for _, name := range names {
+		source, err := newSource(name)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create the source: %s, error: %s", name, err)
+		}
+		service.names = append(service.names, name)
+		service.sources = append(service.sources, source)
+	}
+
+	return service, nil
+}
+
+// newSource creates a specific machine source
+func newSource(name string) (MachineSource, error) {
+	glog.V(3).Infof("Creating the machine source: %s", name)
+	var source MachineSource
+	var err error
+
+	switch name {
+	case "fleet":
+		source, err = NewFleetInterface()
+	case "etcd":
+		source, err = NewEtcdInterface()
+	case "file":
+		source, err = NewInventoryInterface()
+	case "dns":
+		source, err = NewDNSInterface()
+	case "consul":
+		source, err = NewConsulInterface()
+	default:
+		return nil, fmt.Errorf("unsupported machine source: %s", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return source, nil
+}
+
+// partialError ... the failures of a source which was still able to retrieve some of the machines
+type partialError struct {
+	// the failures encountered
+	errors []error
+}
+
+// Error returns the failures as a single message
+func (r *partialError) Error() string {
+	var list []string
+	for _, err := range r.errors {
+		list = append(list, err.Error())
+	}
+
+	return strings.Join(list, ", ")
+}
+
+// isPartialError checks if the error is only a partial failure, i.e. the machines returned are still usable
+func isPartialError(err error) (*partialError, bool) {
+	partial, ok := err.(*partialError)
+
+	return partial, ok
+}
+
+// GetMachines retrieves and merges the machines from all the sources, keyed by the ip address or machine id. If
+// only some of the sources fail the machines from the others are returned along with a partial error
+func (r MultiSource) GetMachines(ctx context.Context) ([]*Machine, error) {
+	var list []*Machine
+	var failures []error
+	var failed int
+
+	merged := make(map[string]*Machine, 0)
+	mergedIDs := make(map[string]*Machine, 0)
+	for index, source := range r.sources {
+		name := r.names[index]
+		machines, err := source.GetMachines(ctx)
+		if partial, found := isPartialError(err); found {
+			for _, x := range partial.errors {
+				glog.Errorf("Failed to retrieve some of the machines from %s, error: %s", name, x)
+				failures = append(failures, fmt.Errorf("unable to retrieve some of the machines from %s, error: %s", name, x))
+			}
+		} else if err != nil {
+			glog.Errorf("Failed to retrieve a list of machines from %s, error: %s", name, err)
+			failures = append(failures, fmt.Errorf("unable to retrieve the machines from %s, error: %s", name, err))
+			failed++
+			continue
+		}
+		for _, machine := range machines {
+			// step: the machines are matched on the ip address or the machine id
+			existing, found := merged[machine.Name]
+			if !found && machine.ID != "" {
+				existing, found = mergedIDs[machine.ID]
+			}
+			if !found {
+				machine.Sources = []string{name}
+				merged[machine.Name] = machine
+				if machine.ID != "" {
+					mergedIDs[machine.ID] = machine
+				}
+				list = append(list, machine)
+				continue
+			}
+			// step: the earlier sources take precedence on any conflicts
+			glog.V(5).Infof("Merging the machine: %s from source: %s", machine.Name, name)
+			existing.Sources = append(existing.Sources, name)
+			if existing.NodeName == "" {
+				existing.NodeName = machine.NodeName
+			}
+			if existing.ID == "" && machine.ID != "" {
+				existing.ID = machine.ID
+				mergedIDs[machine.ID] = existing
+			}
+			if existing.Version == "" {
+				existing.Version = machine.Version
+			}
+			existing.Addresses = mergeAddresses(existing.Addresses, machine.Addresses)
+			for key, value := range machine.Metadata {
+				if current, found := existing.Metadata[key]; !found {
+					existing.Metadata[key] = value
+				} else if current != value {
+					glog.V(4).Infof("Ignoring the metadata: %s=%s on machine: %s from source: %s, conflicts with: %s",
+						key, value, machine.Name, name, current)
+				}
+			}
+		}
+	}
+	// step: we only fail if none of the sources could be reached
+	if failed > 0 && failed == len(r.sources) {
+		return nil, fmt.Errorf("unable to retrieve the machines from any of the sources: %s", strings.Join(r.names, ","))
+	}
+	if len(failures) > 0 {
+		return list, &partialError{errors: failures}
+	}
+
+	return list, nil
+}
+
+// mergeAddresses appends any addresses not already in the list
+func mergeAddresses(addresses, others []string) []string {
+	for _, address := range others {
+		if !containsString(addresses, address) {
+			addresses = append(addresses, address)
+		}
+	}
+
+	return addresses
+}
+
+// Watch fans in the changes from all the sources
+func (r MultiSource) Watch(stop chan struct{}) chan struct{} {
+	updates := make(chan struct{}, 1)
+	for index, source := range r.sources {
+		changes := source.Watch(stop)
+		if changes == nil {
+			continue
+		}
+		go func(name string, changes chan struct{}) {
+			for {
+				select {
+				case <-stop:
+					return
+				case <-changes:
+					glog.V(4).Infof("Machines have changed in the source: %s", name)
+					select {
+					case updates <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}(r.names[index], changes)
+	}
+
+	return updates
+}
+
+// getMachine retrieves our machine from the source
+func getMachine(ctx context.Context, source MachineSource, address string) (*Machine, error) {
+	// step: get all the machines
+	machines, err := source.GetMachines(ctx)
+	partial, isPartial := isPartialError(err)
+	if err != nil && !isPartial {
+		return nil, err
+	}
+	// step: iterate and find the machine
+	for _, machine := range machines {
+		if machine.Name == address {
+			return machine, nil
+		}
+	}
+	// step: the machine may be in a source we could not reach
+	if isPartial {
+		return nil, fmt.Errorf("unable to find the machine: %s in the list of machines, error: %s", address, partial)
+	}
+
+	return nil, fmt.Errorf("unable to find the machine: %s in the list of machines", address)
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// fakeSource ... a machine source returning a fixed list of machines or an error
+type fakeSource struct {
+	machines []*Machine
+	err      error
+}
+
+func (r fakeSource) GetMachines(ctx context.Context) ([]*Machine, error) {
+	return r.machines, r.err
+}
+
+func (r fakeSource) Watch(stop chan struct{}) chan struct{} {
+	return nil
+}
+
+func TestMultiSourcePartialFailure(t *testing.T) {
+	source := MultiSource{
+		names: []string{"file", "consul", "dns"},
+		sources: []MachineSource{
+			fakeSource{machines: []*Machine{{Name: "10.0.0.1", Metadata: map[string]string{}}}},
+			fakeSource{err: fmt.Errorf("connection refused")},
+			fakeSource{
+				machines: []*Machine{{Name: "10.0.0.2", Metadata: map[string]string{}}},
+				err:      &partialError{errors: []error{fmt.Errorf("no such host")}},
+			},
+		},
+	}
+	machines, err := source.GetMachines(context.Background())
+	partial, found := isPartialError(err)
+	if !found {
+		t.Fatalf("expected a partial error, got: %v", err)
+	}
+	if len(partial.errors) != 2 {
+		t.Errorf("expected 2 failures, got: %v", partial.errors)
+	}
+	if len(machines) != 2 {
+		t.Fatalf("expected 2 machines, got: %d", len(machines))
+	}
+	if machines[1].Sources[0] != "dns" {
+		t.Errorf("expected the machine from dns, got: %v", machines[1].Sources)
+	}
+
+	// step: when every source fails the error is not partial
+	source.sources = source.sources[1:2]
+	source.names = source.names[1:2]
+	machines, err = source.GetMachines(context.Background())
+	if _, found := isPartialError(err); err == nil || found || machines != nil {
+		t.Errorf("expected a complete failure, got: %v, machines: %v", err, machines)
+	}
+
+	// step: no failures, no error
+	source.sources = []MachineSource{fakeSource{machines: []*Machine{{Name: "10.0.0.1", Metadata: map[string]string{}}}}}
+	source.names = []string{"file"}
+	if _, err := source.GetMachines(context.Background()); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	cadvisor "github.com/google/cadvisor/info/v1"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/resource"
+	"k8s.io/kubernetes/pkg/util"
+)
+
+// capacityBucket ... the minimum capacity a machine must have to be given the instance size
+type capacityBucket struct {
+	// the name of the instance size
+	name string
+	// the minimum number of cores
+	cores int
+	// the minimum memory in bytes
+	memory int64
+}
+
+// machineSpec retrieves the machine spec from the kubelet running on the address
+func machineSpec(ctx context.Context, address string) (*cadvisor.MachineInfo, error) {
+	glog.V(4).Infof("Retrieving the machine spec from the kubelet: %s on port: %d", address, config.kubeHealthPort)
+	url := fmt.Sprintf("http://%s:%d/spec/", address, config.kubeHealthPort)
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := http.DefaultClient.Do(request.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve the machine spec from: %s, error: %s", address, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to retrieve the machine spec from: %s, status: %s", address, response.Status)
+	}
+
+	spec := new(cadvisor.MachineInfo)
+	if err := json.NewDecoder(response.Body).Decode(spec); err != nil {
+		return nil, fmt.Errorf("unable to decode the machine spec from: %s, error: %s", address, err)
+	}
+
+	return spec, nil
+}
+
+// nodeCapacity converts the machine spec into the node capacity
+func nodeCapacity(spec *cadvisor.MachineInfo) api.ResourceList {
+	return api.ResourceList{
+		api.ResourceCPU:    *resource.NewMilliQuantity(int64(spec.NumCores*1000), resource.DecimalSI),
+		api.ResourceMemory: *resource.NewQuantity(spec.MemoryCapacity, resource.BinarySI),
+	}
+}
+
+// nodeSystemInfo converts the machine spec into the node system info
+func nodeSystemInfo(spec *cadvisor.MachineInfo) api.NodeSystemInfo {
+	return api.NodeSystemInfo{
+		MachineID:  spec.MachineID,
+		SystemUUID: spec.SystemUUID,
+		BootID:     spec.BootID,
+	}
+}
+
+// instanceSize returns the largest capacity bucket the machine satisfies, or an empty string
+func instanceSize(buckets []capacityBucket, spec *cadvisor.MachineInfo) string {
+	size := ""
+	for _, bucket := range buckets {
+		if spec.NumCores >= bucket.cores && spec.MemoryCapacity >= bucket.memory {
+			size = bucket.name
+		}
+	}
+
+	return size
+}
+
+// parseCapacityBuckets parses a comma separated list of capacity buckets, i.e. small=1:2Gi,medium=4:8Gi,
+// the buckets should be in ascending order of capacity
+func parseCapacityBuckets(value string) ([]capacityBucket, error) {
+	var buckets []capacityBucket
+	if value == "" {
+		return buckets, nil
+	}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		items := strings.SplitN(item, "=", 2)
+		if len(items) != 2 || items[0] == "" {
+			return nil, fmt.Errorf("invalid size bucket: '%s', should be name=cores:memory format", item)
+		}
+		if !util.IsValidLabelValue(items[0]) {
+			return nil, fmt.Errorf("invalid size bucket: '%s', the name is not a valid label value", item)
+		}
+		limits := strings.SplitN(items[1], ":", 2)
+		if len(limits) != 2 {
+			return nil, fmt.Errorf("invalid size bucket: '%s', should be name=cores:memory format", item)
+		}
+		cores, err := strconv.Atoi(limits[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid size bucket: '%s', the cores are not a number", item)
+		}
+		memory, err := resource.ParseQuantity(limits[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid size bucket: '%s', the memory is invalid, error: %s", item, err)
+		}
+		buckets = append(buckets, capacityBucket{name: items[0], cores: cores, memory: memory.Value()})
+	}
+
+	return buckets, nil
+}
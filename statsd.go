@@ -0,0 +1,89 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// NewStatsdClient creates a client pushing the metrics to a statsd or dogstatsd endpoint over udp
+func NewStatsdClient(address, prefix string, tags []string, dogstatsd bool) (*StatsdClient, error) {
+	glog.V(3).Infof("Creating a statsd client, address: %s, prefix: %s, dogstatsd: %t", address, prefix, dogstatsd)
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the statsd client, address: %s, error: %s", address, err)
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix = prefix + "."
+	}
+
+	return &StatsdClient{
+		conn:      conn,
+		prefix:    prefix,
+		tags:      tags,
+		dogstatsd: dogstatsd,
+	}, nil
+}
+
+// Count increments the counter
+func (r *StatsdClient) Count(name string, value int64, tags ...string) {
+	r.send(name, fmt.Sprintf("%d|c", value), tags)
+}
+
+// Gauge sets the value of the gauge
+func (r *StatsdClient) Gauge(name string, value int64, tags ...string) {
+	r.send(name, fmt.Sprintf("%d|g", value), tags)
+}
+
+// Timing records the duration in milliseconds
+func (r *StatsdClient) Timing(name string, duration time.Duration, tags ...string) {
+	r.send(name, fmt.Sprintf("%d|ms", int64(duration/time.Millisecond)), tags)
+}
+
+// send writes the metric, a nil client discards the metric so callers need not check it's enabled
+func (r *StatsdClient) send(name, value string, tags []string) {
+	if r == nil {
+		return
+	}
+	metric := r.prefix + name + ":" + value
+	// step: tags are a dogstatsd extension, plain statsd would reject them
+	if r.dogstatsd {
+		if all := append(append([]string{}, r.tags...), tags...); len(all) > 0 {
+			metric += "|#" + strings.Join(all, ",")
+		}
+	}
+	if _, err := r.conn.Write([]byte(metric)); err != nil {
+		glog.V(5).Infof("Unable to send the metric: %s to statsd, error: %s", metric, err)
+	}
+}
+
+// parseStatsdTags parses a comma separated list of tags, i.e. env:prod,dc:eu
+func parseStatsdTags(value string) []string {
+	var tags []string
+	for _, tag := range strings.Split(value, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	return tags
+}
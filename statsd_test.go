@@ -0,0 +1,112 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// testStatsdListener ... a local udp listener receiving the metrics
+func testStatsdListener(t *testing.T) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("unable to create the statsd listener, error: %s", err)
+	}
+
+	return conn
+}
+
+// receiveMetric reads the next metric from the listener
+func receiveMetric(t *testing.T, conn *net.UDPConn) string {
+	buffer := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	size, err := conn.Read(buffer)
+	if err != nil {
+		t.Fatalf("failed to receive the metric, error: %s", err)
+	}
+
+	return string(buffer[:size])
+}
+
+func TestStatsdSend(t *testing.T) {
+	listener := testStatsdListener(t)
+	defer listener.Close()
+
+	tests := []struct {
+		prefix    string
+		tags      []string
+		dogstatsd bool
+		send      func(*StatsdClient)
+		expected  string
+	}{
+		{
+			prefix:   "node-register",
+			send:     func(r *StatsdClient) { r.Count("registrations", 1, "cluster:default") },
+			expected: "node-register.registrations:1|c",
+		},
+		{
+			prefix:   "node-register.",
+			tags:     []string{"env:prod"},
+			send:     func(r *StatsdClient) { r.Gauge("machines", 12) },
+			expected: "node-register.machines:12|g",
+		},
+		{
+			send:     func(r *StatsdClient) { r.Timing("sync.duration", 1500*time.Millisecond) },
+			expected: "sync.duration:1500|ms",
+		},
+		{
+			prefix:    "node-register",
+			tags:      []string{"env:prod", "dc:eu"},
+			dogstatsd: true,
+			send:      func(r *StatsdClient) { r.Count("reaps", 2, "cluster:default") },
+			expected:  "node-register.reaps:2|c|#env:prod,dc:eu,cluster:default",
+		},
+		{
+			prefix:    "node-register",
+			dogstatsd: true,
+			send:      func(r *StatsdClient) { r.Gauge("machines", 3) },
+			expected:  "node-register.machines:3|g",
+		},
+	}
+	for i, test := range tests {
+		client, err := NewStatsdClient(listener.LocalAddr().String(), test.prefix, test.tags, test.dogstatsd)
+		if err != nil {
+			t.Fatalf("case %d, failed to create the client, error: %s", i, err)
+		}
+		test.send(client)
+		if metric := receiveMetric(t, listener); metric != test.expected {
+			t.Errorf("case %d, expected the metric: %q, got: %q", i, test.expected, metric)
+		}
+		client.conn.Close()
+	}
+}
+
+func TestStatsdNilClient(t *testing.T) {
+	var client *StatsdClient
+	client.Count("registrations", 1)
+	client.Gauge("machines", 1)
+	client.Timing("sync.duration", time.Second)
+}
+
+func TestParseStatsdTags(t *testing.T) {
+	tags := parseStatsdTags(" env:prod, ,dc:eu,")
+	if len(tags) != 2 || tags[0] != "env:prod" || tags[1] != "dc:eu" {
+		t.Errorf("unexpected tags: %v", tags)
+	}
+}
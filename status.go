@@ -0,0 +1,211 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	// the machine did not match any of the clusters
+	verdictUnrouted = "unrouted"
+	// the machine did not match the cluster selector
+	verdictFiltered = "filtered"
+	// the machine was skipped as the hostname could not be resolved
+	verdictSkipped = "skipped"
+	// the machine failed the health check
+	verdictUnhealthy = "unhealthy"
+	// the node was registered
+	verdictRegistered = "registered"
+	// the node was deleted and registered again
+	verdictReplaced = "replaced"
+	// the node was updated from the metadata
+	verdictUpdated = "updated"
+	// the node is registered and in sync
+	verdictUnchanged = "unchanged"
+	// an error occurred handling the machine
+	verdictFailed = "failed"
+)
+
+const (
+	// the number of health checks to keep per node
+	healthHistorySize = 10
+	// the time after which a machine no longer seen is dropped from the status
+	machineStatusExpiry = time.Duration(1) * time.Hour
+)
+
+// machineStatus ... the verdict reached for a machine in the last sync
+type machineStatus struct {
+	// the ip address of the machine
+	Address string `json:"address"`
+	// the machine id
+	ID string `json:"id,omitempty"`
+	// the node name the machine is registered as
+	NodeName string `json:"node_name,omitempty"`
+	// the cluster the machine was routed to
+	Cluster string `json:"cluster,omitempty"`
+	// the sources which reported the machine
+	Sources []string `json:"sources,omitempty"`
+	// the metadata of the machine
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// the verdict reached
+	Verdict string `json:"verdict"`
+	// the reason for the verdict
+	Reason string `json:"reason,omitempty"`
+	// the time the machine was last checked
+	Checked time.Time `json:"checked"`
+}
+
+// healthCheck ... the result of a health check on a node
+type healthCheck struct {
+	// the time of the check
+	Time time.Time `json:"time"`
+	// whether the node was healthy
+	Healthy bool `json:"healthy"`
+}
+
+// NewRegisterStatus creates the status tracker for the service
+func NewRegisterStatus() *RegisterStatus {
+	return &RegisterStatus{
+		started:  time.Now(),
+		machines: make(map[string]*machineStatus, 0),
+		health:   make(map[string][]healthCheck, 0),
+	}
+}
+
+// BeginSync marks the start of a sync
+func (r *RegisterStatus) BeginSync() {
+	r.Lock()
+	defer r.Unlock()
+	r.errors = nil
+}
+
+// EndSync marks the end of a sync, recording whether the machine source was reachable
+func (r *RegisterStatus) EndSync(started time.Time, reachable bool) {
+	r.Lock()
+	defer r.Unlock()
+	r.syncs++
+	r.lastSync = time.Now()
+	r.lastDuration = time.Since(started)
+	r.lastErrors = r.errors
+	r.sourceReachable = reachable
+	// step: drop any machines we have not seen for a while
+	for address, machine := range r.machines {
+		if time.Since(machine.Checked) > machineStatusExpiry {
+			delete(r.machines, address)
+		}
+	}
+	// step: and the health history of any nodes no longer checked, i.e. reaped or renamed
+	for name, history := range r.health {
+		if len(history) <= 0 || time.Since(history[len(history)-1].Time) > machineStatusExpiry {
+			delete(r.health, name)
+		}
+	}
+}
+
+// RecordError records an error encountered in the current sync
+func (r *RegisterStatus) RecordError(err error) {
+	r.Lock()
+	defer r.Unlock()
+	r.errors = append(r.errors, err.Error())
+}
+
+// RecordMachine records the verdict reached for the machine
+func (r *RegisterStatus) RecordMachine(address, cluster string, machine *Machine, verdict, reason string) {
+	status := &machineStatus{
+		Address:  address,
+		ID:       machine.ID,
+		Cluster:  cluster,
+		Sources:  machine.Sources,
+		Metadata: machine.Metadata,
+		Verdict:  verdict,
+		Reason:   reason,
+		Checked:  time.Now(),
+	}
+	if machine.Name != address {
+		status.NodeName = machine.Name
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	r.machines[address] = status
+}
+
+// RecordHealth records the result of a health check on the node
+func (r *RegisterStatus) RecordHealth(name string, healthy bool) {
+	r.Lock()
+	defer r.Unlock()
+	history := append(r.health[name], healthCheck{Time: time.Now(), Healthy: healthy})
+	if len(history) > healthHistorySize {
+		history = history[len(history)-healthHistorySize:]
+	}
+	r.health[name] = history
+}
+
+// Errors returns the errors encountered in the last completed sync
+func (r *RegisterStatus) Errors() []string {
+	r.RLock()
+	defer r.RUnlock()
+	return append([]string{}, r.lastErrors...)
+}
+
+// Machines returns the verdicts of the machines, sorted by address
+func (r *RegisterStatus) Machines() []*machineStatus {
+	r.RLock()
+	defer r.RUnlock()
+	var list []*machineStatus
+	for _, machine := range r.machines {
+		list = append(list, machine)
+	}
+	sort.Sort(machinesByAddress(list))
+
+	return list
+}
+
+// Health returns the recent health checks of the node
+func (r *RegisterStatus) Health(name string) []healthCheck {
+	r.RLock()
+	defer r.RUnlock()
+	return append([]healthCheck{}, r.health[name]...)
+}
+
+// Healthy checks the sync loop is still running, i.e. it has completed a sync recently
+func (r *RegisterStatus) Healthy() bool {
+	r.RLock()
+	defer r.RUnlock()
+	last := r.lastSync
+	if last.IsZero() {
+		last = r.started
+	}
+
+	return time.Since(last) < (3*config.timeInterval + r.lastDuration)
+}
+
+// Ready checks a sync has completed and the machine source was reachable
+func (r *RegisterStatus) Ready() bool {
+	r.RLock()
+	defer r.RUnlock()
+	return r.syncs > 0 && r.sourceReachable
+}
+
+// machinesByAddress ... sorts the machine statuses by address
+type machinesByAddress []*machineStatus
+
+func (r machinesByAddress) Len() int           { return len(r) }
+func (r machinesByAddress) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r machinesByAddress) Less(i, j int) bool { return r[i].Address < r[j].Address }
@@ -0,0 +1,58 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRegisterStatusExpiry(t *testing.T) {
+	status := NewRegisterStatus()
+	status.BeginSync()
+	status.RecordHealth("worker-1", true)
+	status.RecordHealth("reaped", false)
+	status.RecordMachine("10.0.0.1", "default", &Machine{Name: "10.0.0.1"}, verdictRegistered, "")
+	status.RecordMachine("10.0.0.2", "default", &Machine{Name: "10.0.0.2"}, verdictRegistered, "")
+	// step: age the entries of the node and machine we no longer see
+	status.health["reaped"][0].Time = time.Now().Add(-machineStatusExpiry - time.Minute)
+	status.machines["10.0.0.2"].Checked = time.Now().Add(-machineStatusExpiry - time.Minute)
+	status.EndSync(time.Now(), true)
+
+	if len(status.Health("worker-1")) != 1 {
+		t.Errorf("expected the health of worker-1 to be kept")
+	}
+	if len(status.Health("reaped")) != 0 {
+		t.Errorf("expected the health of the reaped node to have expired")
+	}
+	if _, found := status.machines["10.0.0.1"]; !found {
+		t.Errorf("expected the machine 10.0.0.1 to be kept")
+	}
+	if _, found := status.machines["10.0.0.2"]; found {
+		t.Errorf("expected the machine 10.0.0.2 to have expired")
+	}
+}
+
+func TestRegisterStatusHealthHistory(t *testing.T) {
+	status := NewRegisterStatus()
+	for i := 0; i < healthHistorySize+5; i++ {
+		status.RecordHealth("worker-1", i%2 == 0)
+	}
+	if history := status.Health("worker-1"); len(history) != healthHistorySize {
+		t.Errorf("expected %d health checks, got: %d", healthHistorySize, len(history))
+	}
+}
@@ -0,0 +1,201 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// note: the vendored go-systemd only carries the unit package, so the notify and native journal
+// protocols are implemented here; both are a single datagram written to a unix socket
+
+const (
+	// the socket journald accepts native protocol messages on
+	journalSocket = "/run/systemd/journal/socket"
+	// the syslog identifier of the journal entries
+	journalIdentifier = "node-register"
+	// the journal priority of an informational message
+	journalPriorityInfo = 6
+)
+
+// loopWatchdog ... tracks whether the sync loop is making progress
+type loopWatchdog struct {
+	sync.Mutex
+	// whether a sync cycle is in progress
+	busy bool
+	// the last time the loop made progress
+	progress time.Time
+}
+
+// the progress of the sync loop, used to decide if we ping the watchdog
+var watchdog = &loopWatchdog{progress: time.Now()}
+
+// sdNotify sends the state to the systemd notify socket, it's a no-op if we were not started by systemd
+func sdNotify(state string) error {
+	name := os.Getenv("NOTIFY_SOCKET")
+	if name == "" {
+		return nil
+	}
+	// step: an @ prefix denotes an abstract socket
+	if strings.HasPrefix(name, "@") {
+		name = "\x00" + name[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("unable to connect to the notify socket, error: %s", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("unable to write to the notify socket, error: %s", err)
+	}
+
+	return nil
+}
+
+// watchdogInterval returns the watchdog interval requested by systemd, or zero if disabled
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	// step: the watchdog may be intended for a different process
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond
+}
+
+// startWatchdog pings the systemd watchdog at half the interval, but only while the loop is progressing;
+// a loop stuck mid cycle stops the pings and systemd restarts us
+func startWatchdog() {
+	interval := watchdogInterval()
+	if interval <= 0 {
+		return
+	}
+	glog.V(3).Infof("Pinging the systemd watchdog every %s", interval/2)
+	go func() {
+		for range time.Tick(interval / 2) {
+			if !watchdog.Progressing(interval) {
+				glog.Warningf("The sync loop has not progressed within the watchdog interval: %s, skipping the ping", interval)
+				continue
+			}
+			if err := sdNotify("WATCHDOG=1"); err != nil {
+				glog.Errorf("Failed to ping the systemd watchdog, %s", err)
+			}
+		}
+	}()
+}
+
+// Begin marks the start of a sync cycle
+func (r *loopWatchdog) Begin() {
+	r.Lock()
+	defer r.Unlock()
+	r.busy = true
+	r.progress = time.Now()
+}
+
+// Progress marks the loop as having made progress within a cycle
+func (r *loopWatchdog) Progress() {
+	r.Lock()
+	defer r.Unlock()
+	r.progress = time.Now()
+}
+
+// End marks the end of a sync cycle
+func (r *loopWatchdog) End() {
+	r.Lock()
+	defer r.Unlock()
+	r.busy = false
+	r.progress = time.Now()
+}
+
+// Progressing checks the loop is either waiting between cycles or has progressed within the interval
+func (r *loopWatchdog) Progressing(interval time.Duration) bool {
+	r.Lock()
+	defer r.Unlock()
+	return !r.busy || time.Since(r.progress) < interval
+}
+
+// notifyCycle reports the outcome of the last cycle to systemd, marking us ready after the first successful sync
+func notifyCycle(started time.Time) {
+	took := time.Since(started)
+	state := fmt.Sprintf("STATUS=last sync: %s, took: %s, machines: %d, errors: %d",
+		time.Now().Format(time.RFC3339), took-took%time.Millisecond, len(syncStatus.Machines()), len(syncStatus.Errors()))
+	if syncStatus.Ready() {
+		state = "READY=1\n" + state
+	}
+	if err := sdNotify(state); err != nil {
+		glog.Errorf("Failed to notify systemd, %s", err)
+	}
+}
+
+// journalAction writes the action to journald with structured fields
+func journalAction(action *plannedAction) {
+	fields := map[string]string{
+		"MESSAGE":           fmt.Sprintf("%s node: %s, cluster: %s, reason: %s", action.Action, action.Node, action.Cluster, action.Reason),
+		"PRIORITY":          strconv.Itoa(journalPriorityInfo),
+		"SYSLOG_IDENTIFIER": journalIdentifier,
+		"ACTION":            action.Action,
+		"NODE":              action.Node,
+		"CLUSTER":           action.Cluster,
+		"MACHINE_ID":        action.MachineID,
+		"ADDRESS":           action.Address,
+		"REASON":            action.Reason,
+		"DRY_RUN":           strconv.FormatBool(config.dryRun),
+	}
+	if err := journalSend(fields); err != nil {
+		glog.Errorf("Failed to write the %s of node: %s to journald, error: %s", action.Action, action.Node, err)
+	}
+}
+
+// journalSend writes the fields to journald using the native protocol, values containing a newline
+// are written in the length prefixed binary form
+func journalSend(fields map[string]string) error {
+	message := new(bytes.Buffer)
+	for key, value := range fields {
+		if value == "" {
+			continue
+		}
+		if !strings.Contains(value, "\n") {
+			fmt.Fprintf(message, "%s=%s\n", key, value)
+			continue
+		}
+		message.WriteString(key + "\n")
+		binary.Write(message, binary.LittleEndian, uint64(len(value)))
+		message.WriteString(value + "\n")
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write(message.Bytes())
+
+	return err
+}
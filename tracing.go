@@ -0,0 +1,283 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// the timeout of an export to the collector
+	otlpExportTimeout = time.Duration(10) * time.Second
+	// the otlp status code of a successful span
+	otlpStatusOK = 1
+	// the otlp status code of a failed span
+	otlpStatusError = 2
+	// the otlp kind of an internal span
+	otlpKindInternal = 1
+)
+
+// cycleReport ... the spans recorded during a sync cycle
+type cycleReport struct {
+	// the trace id of the cycle
+	TraceID string `json:"trace_id"`
+	// the time the cycle started
+	Started time.Time `json:"started"`
+	// the duration of the cycle
+	Duration string `json:"duration"`
+	// the number of spans which failed
+	Failures int `json:"failures"`
+	// the spans recorded, the first is the cycle itself
+	Spans []*cycleSpan `json:"spans"`
+}
+
+// cycleSpan ... a timed operation within a sync cycle
+type cycleSpan struct {
+	// the span id
+	ID string `json:"id"`
+	// the id of the parent span
+	ParentID string `json:"parent_id,omitempty"`
+	// the name of the operation
+	Name string `json:"name"`
+	// the time the operation started
+	Started time.Time `json:"started"`
+	// the time the operation finished
+	Finished time.Time `json:"finished"`
+	// the duration of the operation
+	Duration string `json:"duration"`
+	// the outcome of the operation, ok or error
+	Outcome string `json:"outcome"`
+	// the error if the operation failed
+	Error string `json:"error,omitempty"`
+	// the attributes of the operation, i.e. the node or address
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// NewCycleTracer creates a tracer keeping the last size cycles, optionally exporting them to an otlp/http collector
+func NewCycleTracer(size int, endpoint string) *CycleTracer {
+	return &CycleTracer{
+		size:     size,
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		client:   &http.Client{Timeout: otlpExportTimeout},
+	}
+}
+
+// Begin starts a new cycle
+func (r *CycleTracer) Begin() {
+	r.Lock()
+	defer r.Unlock()
+	root := &cycleSpan{ID: newSpanID(), Name: "sync", Started: time.Now()}
+	r.current = &cycleReport{
+		TraceID: newTraceID(),
+		Started: root.Started,
+		Spans:   []*cycleSpan{root},
+	}
+}
+
+// Start starts a span within the current cycle, returning nil if no cycle is in progress
+func (r *CycleTracer) Start(name string, attributes ...string) *cycleSpan {
+	watchdog.Progress()
+	r.Lock()
+	defer r.Unlock()
+	if r.current == nil {
+		return nil
+	}
+	span := &cycleSpan{
+		ID:         newSpanID(),
+		ParentID:   r.current.Spans[0].ID,
+		Name:       name,
+		Started:    time.Now(),
+		Attributes: make(map[string]string, 0),
+	}
+	for i := 0; i+1 < len(attributes); i += 2 {
+		span.Attributes[attributes[i]] = attributes[i+1]
+	}
+	r.current.Spans = append(r.current.Spans, span)
+
+	return span
+}
+
+// End finishes the current cycle, adding it to the history and exporting it
+func (r *CycleTracer) End() {
+	r.Lock()
+	cycle := r.current
+	r.current = nil
+	if cycle == nil {
+		r.Unlock()
+		return
+	}
+	cycle.Spans[0].finish(nil)
+	for _, span := range cycle.Spans {
+		if span.Outcome == "error" {
+			cycle.Failures++
+		}
+	}
+	cycle.Duration = cycle.Spans[0].Duration
+	r.history = append(r.history, cycle)
+	if len(r.history) > r.size {
+		r.history = r.history[len(r.history)-r.size:]
+	}
+	r.Unlock()
+
+	glog.V(4).Infof("Sync cycle: %s took %s, spans: %d, failures: %d", cycle.TraceID, cycle.Duration, len(cycle.Spans), cycle.Failures)
+	if r.endpoint != "" {
+		r.exports.Add(1)
+		go func() {
+			defer r.exports.Done()
+			if err := r.export(cycle); err != nil {
+				glog.Errorf("Failed to export the sync cycle to the collector: %s, error: %s", r.endpoint, err)
+			}
+		}()
+	}
+}
+
+// Flush waits for the exports in progress to finish
+func (r *CycleTracer) Flush() {
+	r.exports.Wait()
+}
+
+// History returns the recent cycles, newest first
+func (r *CycleTracer) History() []*cycleReport {
+	r.RLock()
+	defer r.RUnlock()
+	list := []*cycleReport{}
+	for i := len(r.history) - 1; i >= 0; i-- {
+		list = append(list, r.history[i])
+	}
+
+	return list
+}
+
+// End finishes the span with the outcome of the operation, a nil span is ignored
+func (r *cycleSpan) End(err error) {
+	if r == nil {
+		return
+	}
+	watchdog.Progress()
+	cycleTracer.Lock()
+	defer cycleTracer.Unlock()
+	r.finish(err)
+}
+
+// finish records the end time and outcome of the span
+func (r *cycleSpan) finish(err error) {
+	r.Finished = time.Now()
+	r.Duration = r.Finished.Sub(r.Started).String()
+	r.Outcome = "ok"
+	if err != nil {
+		r.Outcome = "error"
+		r.Error = err.Error()
+	}
+}
+
+// export posts the cycle to the collector in the otlp/http json encoding
+func (r *CycleTracer) export(cycle *cycleReport) error {
+	var spans []map[string]interface{}
+	for _, span := range cycle.Spans {
+		status := map[string]interface{}{"code": otlpStatusOK}
+		if span.Outcome == "error" {
+			status = map[string]interface{}{"code": otlpStatusError, "message": span.Error}
+		}
+		var attributes []map[string]interface{}
+		for key, value := range span.Attributes {
+			attributes = append(attributes, otlpAttribute(key, value))
+		}
+		spans = append(spans, map[string]interface{}{
+			"traceId":           cycle.TraceID,
+			"spanId":            span.ID,
+			"parentSpanId":      span.ParentID,
+			"name":              span.Name,
+			"kind":              otlpKindInternal,
+			"startTimeUnixNano": strconv.FormatInt(span.Started.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(span.Finished.UnixNano(), 10),
+			"attributes":        attributes,
+			"status":            status,
+		})
+	}
+	payload := map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []interface{}{
+						otlpAttribute("service.name", "node-register"),
+						otlpAttribute("service.version", Version),
+						otlpAttribute("service.instance.id", config.instanceID),
+					},
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "node-register"},
+						"spans": spans,
+					},
+				},
+			},
+		},
+	}
+	content, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	response, err := r.client.Post(r.endpoint+"/v1/traces", "application/json", bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("unexpected response: %s", response.Status)
+	}
+
+	return nil
+}
+
+// otlpAttribute encodes a string attribute
+func otlpAttribute(key, value string) map[string]interface{} {
+	return map[string]interface{}{
+		"key":   key,
+		"value": map[string]interface{}{"stringValue": value},
+	}
+}
+
+// newTraceID generates a random 16 byte trace id
+func newTraceID() string {
+	return randomHex(16)
+}
+
+// newSpanID generates a random 8 byte span id
+func newSpanID() string {
+	return randomHex(8)
+}
+
+// randomHex returns n random bytes hex encoded
+func randomHex(n int) string {
+	id := make([]byte, n)
+	if _, err := rand.Read(id); err != nil {
+		glog.Errorf("Unable to generate a random id, error: %s", err)
+	}
+
+	return hex.EncodeToString(id)
+}
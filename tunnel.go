@@ -0,0 +1,228 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// sshTunnel ... an ssh connection used to reach the fleet api, akin to fleetctl --tunnel
+type sshTunnel struct {
+	sync.Mutex
+	// the address of the tunnel host
+	address string
+	// the ssh client configuration
+	config *ssh.ClientConfig
+	// the timeout for establishing the connection
+	timeout time.Duration
+	// the current ssh connection
+	client *ssh.Client
+}
+
+// newSSHTunnel ... creates a ssh tunnel to the host, authenticating either with a key or the ssh agent and verifying
+// the host key against the known hosts file
+func newSSHTunnel(address, user, keyFile, knownHostsFile string, strict bool, timeout time.Duration) (*sshTunnel, error) {
+	glog.V(3).Infof("Creating a ssh tunnel to fleet via host: %s, user: %s", address, user)
+	if !strings.Contains(address, ":") {
+		address = address + ":22"
+	}
+
+	var methods []ssh.AuthMethod
+	// step: are we using a private key?
+	if keyFile != "" {
+		content, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the ssh key: %s, error: %s", keyFile, err)
+		}
+		signer, err := ssh.ParsePrivateKey(content)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse the ssh key: %s, error: %s", keyFile, err)
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+
+	// step: are we using the ssh agent?
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			return nil, fmt.Errorf("unable to connect to the ssh agent: %s, error: %s", socket, err)
+		}
+		methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+	}
+
+	if len(methods) <= 0 {
+		return nil, fmt.Errorf("no ssh key specified and the SSH_AUTH_SOCK environment variable is not set")
+	}
+
+	// step: read in the host keys we trust
+	hosts, err := loadKnownHosts(knownHostsFile, strict)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sshTunnel{
+		address: address,
+		timeout: timeout,
+		config: &ssh.ClientConfig{
+			User:            user,
+			Auth:            methods,
+			HostKeyCallback: hosts.HostKeyCallback,
+		},
+	}, nil
+}
+
+// connection ... returns the current ssh connection, establishing a new one if required
+func (r *sshTunnel) connection() (*ssh.Client, error) {
+	r.Lock()
+	defer r.Unlock()
+	if r.client != nil {
+		return r.client, nil
+	}
+
+	glog.V(4).Infof("Establishing a ssh connection to the tunnel host: %s", r.address)
+	conn, err := net.DialTimeout("tcp", r.address, r.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to the tunnel host: %s, error: %s", r.address, err)
+	}
+	sshConn, channels, requests, err := ssh.NewClientConn(conn, r.address, r.config)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unable to establish ssh connection to: %s, error: %s", r.address, err)
+	}
+	client := ssh.NewClient(sshConn, channels, requests)
+	r.client = client
+
+	return client, nil
+}
+
+// reset ... drops the current ssh connection so the next dial reconnects
+func (r *sshTunnel) reset() {
+	r.Lock()
+	defer r.Unlock()
+	if r.client != nil {
+		r.client.Close()
+		r.client = nil
+	}
+}
+
+// Dial ... opens a connection to the address on the far side of the tunnel
+func (r *sshTunnel) Dial(network, address string) (net.Conn, error) {
+	client, err := r.connection()
+	if err != nil {
+		return nil, err
+	}
+
+	// step: unix sockets are forwarded by fleetctl on the remote host
+	if network == "unix" {
+		conn, err := r.forwardSocket(client, address)
+		if err != nil {
+			r.reset()
+		}
+		return conn, err
+	}
+
+	conn, err := client.Dial(network, address)
+	if err != nil {
+		r.reset()
+		return nil, fmt.Errorf("unable to dial: %s via the ssh tunnel, error: %s", address, err)
+	}
+
+	return conn, nil
+}
+
+// forwardSocket ... uses fleetctl fd-forward on the remote host to proxy the fleet unix socket
+func (r *sshTunnel) forwardSocket(client *ssh.Client, socket string) (net.Conn, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("unable to create a ssh session, error: %s", err)
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	if err := session.Start(fmt.Sprintf("fleetctl fd-forward %s", socket)); err != nil {
+		session.Close()
+		return nil, fmt.Errorf("unable to forward the fleet socket: %s, error: %s", socket, err)
+	}
+
+	return &sessionConn{
+		session: session,
+		reader:  stdout,
+		writer:  stdin,
+		local:   client.LocalAddr(),
+		remote:  client.RemoteAddr(),
+	}, nil
+}
+
+// sessionConn ... wraps the stdin / stdout of a ssh session as a net.Conn
+type sessionConn struct {
+	session *ssh.Session
+	reader  io.Reader
+	writer  io.WriteCloser
+	local   net.Addr
+	remote  net.Addr
+}
+
+func (r *sessionConn) Read(b []byte) (int, error) {
+	return r.reader.Read(b)
+}
+
+func (r *sessionConn) Write(b []byte) (int, error) {
+	return r.writer.Write(b)
+}
+
+func (r *sessionConn) Close() error {
+	r.writer.Close()
+	return r.session.Close()
+}
+
+func (r *sessionConn) LocalAddr() net.Addr {
+	return r.local
+}
+
+func (r *sessionConn) RemoteAddr() net.Addr {
+	return r.remote
+}
+
+func (r *sessionConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (r *sessionConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (r *sessionConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
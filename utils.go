@@ -20,6 +20,17 @@ import (
 	"fmt"
 	"net"
 	"strings"
+
+	"k8s.io/kubernetes/pkg/api"
+)
+
+var (
+	// the private ipv4 address ranges
+	privateNetworks = []*net.IPNet{
+		mustParseCIDR("10.0.0.0/8"),
+		mustParseCIDR("172.16.0.0/12"),
+		mustParseCIDR("192.168.0.0/16"),
+	}
 )
 
 func getInterfaceAddress(name string) (string, error) {
@@ -44,3 +55,74 @@ func getInterfaceAddress(name string) (string, error) {
 
 	return "", fmt.Errorf("unable to determine or find the interface: %s", name)
 }
+
+// parseSelector ... parses a comma separated list of tag=value pairs into a map
+func parseSelector(selector string) (map[string]string, error) {
+	tags := make(map[string]string, 0)
+	for _, pair := range strings.Split(selector, ",") {
+		// check: ensure the metadata is valid
+		matches := metadataRegex.FindAllStringSubmatch(strings.TrimSpace(pair), 1)
+		if len(matches) <= 0 {
+			return nil, fmt.Errorf("invalid metadata: '%s', should be tag=value format", pair)
+		}
+		tags[matches[0][1]] = matches[0][2]
+	}
+
+	return tags, nil
+}
+
+// nodeAddresses derives the node addresses from the machine, private addresses are published as internal
+// and public addresses as external, only the address types enabled in the config are returned
+func nodeAddresses(machine *Machine) []api.NodeAddress {
+	var addresses []api.NodeAddress
+	for _, address := range machine.Addresses {
+		ip := net.ParseIP(address)
+		if ip == nil {
+			continue
+		}
+		addressType := api.NodeExternalIP
+		if isPrivateAddress(ip) {
+			addressType = api.NodeInternalIP
+		}
+		if config.nodeAddressTypes[addressType] {
+			addresses = append(addresses, api.NodeAddress{Type: addressType, Address: address})
+		}
+	}
+	if machine.Hostname != "" && config.nodeAddressTypes[api.NodeHostName] {
+		addresses = append(addresses, api.NodeAddress{Type: api.NodeHostName, Address: machine.Hostname})
+	}
+
+	return addresses
+}
+
+// isPrivateAddress checks if the ip address is within a private range
+func isPrivateAddress(ip net.IP) bool {
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mustParseCIDR parses the cidr or panics
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+
+	return network
+}
+
+// containsString checks if the value is in the list
+func containsString(list []string, value string) bool {
+	for _, x := range list {
+		if x == value {
+			return true
+		}
+	}
+
+	return false
+}
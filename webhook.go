@@ -0,0 +1,253 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+)
+
+const (
+	// the header containing the hmac signature of the payload
+	webhookSignatureHeader = "X-Node-Register-Signature"
+	// the header containing the event type
+	webhookEventHeader = "X-Node-Register-Event"
+	// the number of events queued per webhook before we start dropping them
+	webhookQueueSize = 1000
+	// the default number of attempts to deliver an event
+	defaultWebhookRetries = 3
+	// the default timeout of a delivery
+	defaultWebhookTimeout = time.Duration(10) * time.Second
+	// the initial delay between delivery attempts
+	webhookBackoff = time.Duration(1) * time.Second
+	// the maximum delay between delivery attempts
+	webhookMaxBackoff = time.Duration(30) * time.Second
+)
+
+// webhookEvent ... the payload posted to the webhooks
+type webhookEvent struct {
+	// the type of event, i.e. the action taken
+	Event string `json:"event"`
+	// the time of the event
+	Timestamp time.Time `json:"timestamp"`
+	// the cluster the node is in
+	Cluster string `json:"cluster"`
+	// the name of the node
+	Node string `json:"node"`
+	// the ip address of the machine
+	Address string `json:"address,omitempty"`
+	// the id of the machine
+	MachineID string `json:"machine_id,omitempty"`
+	// the reason for the event
+	Reason string `json:"reason,omitempty"`
+	// the fields changed on the node
+	Changes map[string]*fieldChange `json:"changes,omitempty"`
+	// the instance of the service which made the change
+	Instance string `json:"instance"`
+}
+
+// loadWebhooks ... reads in the webhooks from the yaml or json webhooks file
+func loadWebhooks(filename string) ([]*Webhook, error) {
+	glog.V(4).Infof("Reading in the webhooks from file: %s", filename)
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the webhooks file: %s, error: %s", filename, err)
+	}
+
+	var webhooks struct {
+		Webhooks []*Webhook `json:"webhooks"`
+	}
+	if err := yaml.Unmarshal(content, &webhooks); err != nil {
+		return nil, fmt.Errorf("unable to decode the webhooks file: %s, error: %s", filename, err)
+	}
+
+	for index, webhook := range webhooks.Webhooks {
+		if err := validateWebhook(webhook); err != nil {
+			return nil, fmt.Errorf("invalid webhook at index %d, %s", index, err)
+		}
+	}
+
+	return webhooks.Webhooks, nil
+}
+
+// validateWebhook ... checks the webhook and fills in the defaults
+func validateWebhook(webhook *Webhook) error {
+	if webhook.URL == "" {
+		return fmt.Errorf("the webhook does not have a url")
+	}
+	if location, err := url.Parse(webhook.URL); err != nil || (location.Scheme != "http" && location.Scheme != "https") {
+		return fmt.Errorf("the webhook url: %s is not a valid http or https url", webhook.URL)
+	}
+	for _, event := range webhook.Events {
+		switch event {
+		case actionRegister, actionRelabel, actionRecreate, actionReap, actionDeregister:
+		default:
+			return fmt.Errorf("unknown event: %s, should be register, relabel, delete-and-recreate, reap or deregister", event)
+		}
+	}
+	if webhook.Retries <= 0 {
+		webhook.Retries = defaultWebhookRetries
+	}
+	webhook.timeout = defaultWebhookTimeout
+	if webhook.Timeout != "" {
+		timeout, err := time.ParseDuration(webhook.Timeout)
+		if err != nil {
+			return fmt.Errorf("invalid timeout: %s, error: %s", webhook.Timeout, err)
+		}
+		webhook.timeout = timeout
+	}
+
+	return nil
+}
+
+// Start starts delivering the events queued for the webhook
+func (r *Webhook) Start() {
+	r.queue = make(chan *webhookEvent, webhookQueueSize)
+	r.client = &http.Client{Timeout: r.timeout}
+	go func() {
+		for event := range r.queue {
+			r.deliver(event)
+			r.pending.Done()
+		}
+	}()
+}
+
+// Notify queues the event for delivery if the webhook is interested in it, it never blocks
+func (r *Webhook) Notify(event *webhookEvent) {
+	if len(r.Events) > 0 && !containsString(r.Events, event.Event) {
+		return
+	}
+	r.pending.Add(1)
+	select {
+	case r.queue <- event:
+	default:
+		r.pending.Done()
+		glog.Warningf("The queue for the webhook: %s is full, dropping the %s event for node: %s", r.URL, event.Event, event.Node)
+	}
+}
+
+// deliver posts the event to the webhook, retrying with a backoff on failure
+func (r *Webhook) deliver(event *webhookEvent) {
+	content, err := json.Marshal(event)
+	if err != nil {
+		glog.Errorf("Unable to encode the webhook event, error: %s", err)
+		return
+	}
+
+	backoff := webhookBackoff
+	for attempt := 1; attempt <= r.Retries; attempt++ {
+		if err = r.post(event.Event, content); err == nil {
+			glog.V(4).Infof("Delivered the %s event for node: %s to the webhook: %s", event.Event, event.Node, r.URL)
+			return
+		}
+		glog.V(3).Infof("Failed to deliver the %s event to the webhook: %s, attempt: %d, error: %s", event.Event, r.URL, attempt, err)
+		if attempt < r.Retries {
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > webhookMaxBackoff {
+				backoff = webhookMaxBackoff
+			}
+		}
+	}
+	glog.Errorf("Giving up delivering the %s event for node: %s to the webhook: %s, error: %s", event.Event, event.Node, r.URL, err)
+}
+
+// post makes a single delivery attempt
+func (r *Webhook) post(eventType string, content []byte) error {
+	request, err := http.NewRequest("POST", r.URL, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(webhookEventHeader, eventType)
+	for name, value := range r.Headers {
+		request.Header.Set(name, value)
+	}
+	if r.Secret != "" {
+		request.Header.Set(webhookSignatureHeader, "sha256="+signPayload(r.Secret, content))
+	}
+
+	response, err := r.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("unexpected response: %s", response.Status)
+	}
+
+	return nil
+}
+
+// signPayload returns the hex encoded hmac-sha256 of the payload
+func signPayload(secret string, content []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(content)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// notifyWebhooks queues the action for delivery to each of the webhooks
+func notifyWebhooks(action *plannedAction) {
+	if len(webhooks) <= 0 {
+		return
+	}
+	event := &webhookEvent{
+		Event:     action.Action,
+		Timestamp: time.Now().UTC(),
+		Cluster:   action.Cluster,
+		Node:      action.Node,
+		Address:   action.Address,
+		MachineID: action.MachineID,
+		Reason:    action.Reason,
+		Changes:   action.Changes,
+		Instance:  config.instanceID,
+	}
+	for _, webhook := range webhooks {
+		webhook.Notify(event)
+	}
+}
+
+// flushWebhooks waits for the pending events to be delivered, giving up after the timeout
+func flushWebhooks(timeout time.Duration) {
+	if len(webhooks) <= 0 {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		for _, webhook := range webhooks {
+			webhook.pending.Wait()
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		glog.Warningf("Timed out waiting for the events to be delivered to the webhooks")
+	}
+}